@@ -0,0 +1,64 @@
+package httpware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrNoCredentials is returned by an Authenticator for requests which do not
+// carry the credentials it verifies.
+var ErrNoCredentials = errors.New("httpware: no credentials")
+
+// Authenticator verifies one kind of credentials, ie: JWTs or API keys.
+// Authentication middleware such as tokenware implement it, so that they can
+// be combined with AnyOf.
+type Authenticator interface {
+	// Authenticate returns the context for the rest of the request, with the
+	// Principal stored under PrincipalKey. It returns ErrNoCredentials if the
+	// request does not carry the credentials, any other error rejects the
+	// request.
+	Authenticate(ctx context.Context, r *http.Request) (context.Context, error)
+	// Challenge returns the WWW-Authenticate challenge sent with requests
+	// which were rejected with err, ie: `Bearer error="invalid_token"`.
+	Challenge(err error) string
+}
+
+// AnyOf returns middleware which accepts the credentials of any of the
+// authenticators, ie:
+//
+//	httpware.Compose(errWare, httpware.AnyOf(keyMiddle, tokenMiddle))
+//
+// The authenticators are tried in order and the first one which finds its
+// credentials decides, so the more specific ones go first. When the
+// credentials are invalid, the request is rejected with its error, and with
+// its challenge if the error is a 401. Requests without any credentials get a
+// 401 (Unauthorized) response, with the challenges of all of the
+// authenticators.
+func AnyOf(auths ...Authenticator) Middleware {
+	return anyOf(auths)
+}
+
+type anyOf []Authenticator
+
+func (a anyOf) Handle(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		for _, auth := range a {
+			newCtx, err := auth.Authenticate(ctx, r)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil {
+				if StatusOf(err) == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", auth.Challenge(err))
+				}
+				return err
+			}
+			return next.ServeHTTPCtx(newCtx, w, r)
+		}
+		for _, auth := range a {
+			w.Header().Add("WWW-Authenticate", auth.Challenge(ErrNoCredentials))
+		}
+		return NewErr("authentication required", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,66 @@
+package httpware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type headerAuth string
+
+func (a headerAuth) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	v := r.Header.Get(string(a))
+	if v == "" {
+		return ctx, ErrNoCredentials
+	}
+	if v == "down" {
+		return ctx, errors.New("backend is down")
+	}
+	if v != "ok" {
+		return ctx, NewErr("invalid credentials", http.StatusUnauthorized)
+	}
+	return context.WithValue(ctx, PrincipalKey, &Principal{Subject: string(a)}), nil
+}
+
+func (a headerAuth) Challenge(err error) string {
+	return string(a)
+}
+
+func TestAnyOf(t *testing.T) {
+	h := Compose(DefaultErrHandler, AnyOf(headerAuth("A"), headerAuth("B"))).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte(PrincipalFromCtx(ctx).Subject))
+		return nil
+	})
+	cases := []struct {
+		Name       string
+		Headers    map[string]string
+		Status     int
+		Body       string
+		Challenges int
+	}{
+		{"first", map[string]string{"A": "ok"}, http.StatusOK, "A", 0},
+		{"second", map[string]string{"B": "ok"}, http.StatusOK, "B", 0},
+		{"first decides", map[string]string{"A": "bad", "B": "ok"}, http.StatusUnauthorized, "", 1},
+		{"none", nil, http.StatusUnauthorized, "", 2},
+		{"failure", map[string]string{"A": "down"}, http.StatusInternalServerError, "", 0},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range c.Headers {
+			r.Header.Set(k, v)
+		}
+		h.ServeHTTP(rec, r)
+		if rec.Code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, rec.Code)
+		}
+		if c.Status == http.StatusOK && rec.Body.String() != c.Body {
+			t.Fatalf("%s: expected body %q, got %q", c.Name, c.Body, rec.Body.String())
+		}
+		if n := len(rec.Header()["Www-Authenticate"]); n != c.Challenges {
+			t.Fatalf("%s: expected %v challenges, got %v", c.Name, c.Challenges, n)
+		}
+	}
+}
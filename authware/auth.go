@@ -0,0 +1,67 @@
+/*
+Package authware provides middleware for HTTP Basic (RFC 7617) and Digest
+(RFC 7616) authentication. The credentials are checked by a Verifier, ie: an
+Htpasswd file. The middleware implement the httpware.Authenticator
+interface, so they can be combined with other authentication middleware by
+httpware.AnyOf.
+*/
+package authware
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// Verifier checks the password of a user.
+type Verifier interface {
+	// Verify reports whether password is the password of user. Unknown
+	// users are not an error.
+	Verify(user, password string) (bool, error)
+}
+
+// VerifierFunc is an adapter to allow the use of ordinary functions as a
+// Verifier.
+type VerifierFunc func(user, password string) (bool, error)
+
+// Verify fulfills the Verifier interface.
+func (f VerifierFunc) Verify(user, password string) (bool, error) {
+	return f(user, password)
+}
+
+// Passwords maps users to their passwords in clear text. It is both a
+// Verifier and a DigestSecrets, meant for tests and development.
+type Passwords map[string]string
+
+// Verify fulfills the Verifier interface.
+func (p Passwords) Verify(user, password string) (bool, error) {
+	stored, ok := p[user]
+	return ok && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, nil
+}
+
+// HA1 fulfills the DigestSecrets interface.
+func (p Passwords) HA1(user, realm string) (string, error) {
+	password, ok := p[user]
+	if !ok {
+		return "", nil
+	}
+	return DigestHA1(user, realm, password), nil
+}
+
+// authenticated returns the context with the principal of the user.
+func authenticated(ctx context.Context, user, method string, newPrincipal func(string) *httpware.Principal) context.Context {
+	var p *httpware.Principal
+	if newPrincipal != nil {
+		p = newPrincipal(user)
+	}
+	if p == nil {
+		p = &httpware.Principal{Subject: user}
+	}
+	if p.Claims == nil {
+		p.Claims = map[string]interface{}{"sub": user, "authMethod": method}
+	}
+	logware.LoggerFromCtx(ctx).AddField("principal", p.Subject)
+	return context.WithValue(ctx, httpware.PrincipalKey, p)
+}
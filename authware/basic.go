@@ -0,0 +1,80 @@
+package authware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// BasicConfig is used to initialize a new instance of Basic.
+type BasicConfig struct {
+	// The protection space sent in the challenge. Defaults to "Restricted".
+	Realm string
+	// Checks the credentials. Required.
+	Verifier Verifier
+	// Returns the principal of an authenticated user, ie: with the user's
+	// roles. Defaults to a Principal with only the Subject.
+	Principal func(user string) *httpware.Principal
+}
+
+// Basic is middleware for HTTP Basic authentication. It will return an
+// 'Unauthorized' response with a Basic challenge if the credentials are
+// missing or wrong. As Basic authentication sends the password with every
+// request, it must only be used over TLS.
+type Basic struct {
+	conf BasicConfig
+}
+
+// NewBasic returns a new instance of Basic. It panics if
+// BasicConfig.Verifier is nil.
+func NewBasic(conf BasicConfig) *Basic {
+	if conf.Verifier == nil {
+		panic("authware: BasicConfig.Verifier is required")
+	}
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	return &Basic{conf: conf}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (b *Basic) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		newCtx, err := b.Authenticate(ctx, r)
+		if err == httpware.ErrNoCredentials {
+			err = httpware.NewErr("authentication required", http.StatusUnauthorized)
+		}
+		if httpware.StatusOf(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", b.Challenge(err))
+		}
+		if err != nil {
+			return err
+		}
+		return next.ServeHTTPCtx(newCtx, w, r)
+	})
+}
+
+// Authenticate fulfills the httpware.Authenticator interface.
+func (b *Basic) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return ctx, httpware.ErrNoCredentials
+	}
+	valid, err := b.conf.Verifier.Verify(user, password)
+	if err != nil {
+		return ctx, err
+	}
+	if !valid {
+		logware.LoggerFromCtx(ctx).AddField("authError", "wrong credentials for "+user)
+		return ctx, httpware.NewErr("invalid credentials", http.StatusUnauthorized)
+	}
+	return authenticated(ctx, user, "basic", b.conf.Principal), nil
+}
+
+// Challenge fulfills the httpware.Authenticator interface.
+func (b *Basic) Challenge(err error) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, b.conf.Realm)
+}
@@ -0,0 +1,106 @@
+package authware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/tokenware"
+)
+
+func TestBasic(t *testing.T) {
+	var p *httpware.Principal
+	h := httpware.Compose(httpware.DefaultErrHandler, NewBasic(BasicConfig{
+		Realm:    "admin",
+		Verifier: Passwords{"alice": "secret"},
+		Principal: func(user string) *httpware.Principal {
+			return &httpware.Principal{Subject: user, Roles: []string{"admin"}}
+		},
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p = httpware.PrincipalFromCtx(ctx)
+		return nil
+	})
+
+	cases := []struct {
+		Name     string
+		User     string
+		Password string
+		Status   int
+	}{
+		{"valid", "alice", "secret", http.StatusOK},
+		{"wrong password", "alice", "guess", http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized},
+		{"missing", "", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		p = nil
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.User != "" {
+			r.SetBasicAuth(c.User, c.Password)
+		}
+		h.ServeHTTP(rec, r)
+		if rec.Code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, rec.Code)
+		}
+		if c.Status == http.StatusOK {
+			if p == nil || p.Subject != "alice" || !p.HasRole("admin") {
+				t.Fatalf("%s: unexpected principal: %+v", c.Name, p)
+			}
+			continue
+		}
+		if ch := rec.Header().Get("WWW-Authenticate"); ch != `Basic realm="admin", charset="UTF-8"` {
+			t.Fatalf("%s: unexpected challenge: %q", c.Name, ch)
+		}
+	}
+}
+
+func TestBasicVerifierFailure(t *testing.T) {
+	h := httpware.Compose(httpware.DefaultErrHandler, NewBasic(BasicConfig{
+		Verifier: VerifierFunc(func(user, password string) (bool, error) {
+			return false, errors.New("backend is down")
+		}),
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code %v, got %v", http.StatusInternalServerError, rec.Code)
+	}
+	if ch := rec.Header().Get("WWW-Authenticate"); ch != "" {
+		t.Fatalf("expected no challenge, got %q", ch)
+	}
+}
+
+func TestBasicAfterTokens(t *testing.T) {
+	tokens := tokenware.New(tokenware.Config{Algorithms: []string{"HS256"}, Secret: []byte("shh")})
+	basic := NewBasic(BasicConfig{Verifier: Passwords{"alice": "secret"}})
+	var user string
+	h := httpware.Compose(httpware.DefaultErrHandler, httpware.AnyOf(tokens, basic)).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		user = httpware.PrincipalFromCtx(ctx).Subject
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || user != "alice" {
+		t.Fatalf("expected the Basic credentials to be verified, got %v %q", rec.Code, user)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "guess")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("expected a Basic challenge, got %v %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+}
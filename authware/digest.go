@@ -0,0 +1,300 @@
+package authware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// DigestSecrets provides the secrets which Digest authentication is checked
+// against. The server does not need the passwords, only the HA1 hashes.
+type DigestSecrets interface {
+	// HA1 returns DigestHA1 of the user, or "" for unknown users. Errors
+	// are returned by Digest as they are, rather than rejecting the user.
+	HA1(user, realm string) (string, error)
+}
+
+// DigestHA1 returns the hex encoded SHA-256 hash of "user:realm:password".
+func DigestHA1(user, realm, password string) string {
+	return sha256Hex(user + ":" + realm + ":" + password)
+}
+
+// DigestConfig is used to initialize a new instance of Digest.
+type DigestConfig struct {
+	// The protection space sent in the challenge, which is part of HA1.
+	// Defaults to "Restricted".
+	Realm string
+	// Provides the HA1 hashes of the users. Required.
+	Secrets DigestSecrets
+	// How long a nonce can be used. Clients retry expired nonces without
+	// asking the user again. Defaults to 5 minutes.
+	NonceTTL time.Duration
+	// The secret which signs the nonces. Replicas behind a load balancer
+	// must share it, so that each accepts the nonces of the others; it
+	// should also survive restarts. Defaults to a random key, then nonces
+	// of other processes are answered with a stale challenge and the client
+	// retries without asking the user again.
+	Key []byte
+	// Returns the principal of an authenticated user, ie: with the user's
+	// roles. Defaults to a Principal with only the Subject.
+	Principal func(user string) *httpware.Principal
+}
+
+// Digest is middleware for HTTP Digest authentication with the SHA-256
+// algorithm and the "auth" quality of protection. It will return an
+// 'Unauthorized' response with a Digest challenge if the credentials are
+// missing or wrong. Nonces are signed rather than stored, the nonce counts
+// which were used are remembered to reject replayed requests.
+type Digest struct {
+	conf   DigestConfig
+	key    []byte
+	opaque string
+
+	mutex sync.Mutex
+	// The highest nonce count used with each nonce.
+	counts map[string]uint64
+	swept  time.Time
+	now    func() time.Time
+}
+
+// NewDigest returns a new instance of Digest. It panics if
+// DigestConfig.Secrets is nil.
+func NewDigest(conf DigestConfig) *Digest {
+	if conf.Secrets == nil {
+		panic("authware: DigestConfig.Secrets is required")
+	}
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	if conf.NonceTTL == 0 {
+		conf.NonceTTL = 5 * time.Minute
+	}
+	key := conf.Key
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &Digest{
+		conf:   conf,
+		key:    key,
+		opaque: sha256Hex(string(key))[:32],
+		counts: make(map[string]uint64),
+		now:    time.Now,
+	}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (d *Digest) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		newCtx, err := d.Authenticate(ctx, r)
+		if err == httpware.ErrNoCredentials {
+			err = httpware.NewErr("authentication required", http.StatusUnauthorized)
+		}
+		if httpware.StatusOf(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", d.Challenge(err))
+		}
+		if err != nil {
+			return err
+		}
+		return next.ServeHTTPCtx(newCtx, w, r)
+	})
+}
+
+// staleNonce is the reason given by verify for a correct response to an
+// expired nonce. The client retries with the new nonce of the challenge,
+// which is marked as stale.
+const staleNonce = "stale nonce"
+
+// CodeStaleNonce is the "code" of errors for expired nonces.
+const CodeStaleNonce = "stale_nonce"
+
+// Authenticate fulfills the httpware.Authenticator interface.
+func (d *Digest) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "digest ") {
+		return ctx, httpware.ErrNoCredentials
+	}
+	user, reason, err := d.verify(r, parseParams(auth[7:]))
+	if err != nil {
+		return ctx, err
+	}
+	if reason != "" {
+		logware.LoggerFromCtx(ctx).AddField("authError", reason)
+		if reason == staleNonce {
+			return ctx, httpware.NewErr("stale nonce", http.StatusUnauthorized).WithField("code", CodeStaleNonce)
+		}
+		return ctx, httpware.NewErr("invalid credentials", http.StatusUnauthorized)
+	}
+	return authenticated(ctx, user, "digest", d.conf.Principal), nil
+}
+
+// Challenge fulfills the httpware.Authenticator interface.
+func (d *Digest) Challenge(err error) string {
+	stale := ""
+	if e, ok := err.(httpware.Err); ok && e.Fields["code"] == CodeStaleNonce {
+		stale = ", stale=true"
+	}
+	return fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=SHA-256, nonce=%q, opaque=%q%s`,
+		d.conf.Realm, d.nonce(d.now()), d.opaque, stale)
+}
+
+// verify checks the Authorization parameters and returns the user, or the
+// reason why the credentials are rejected. Errors of the DigestSecrets are
+// returned as they are.
+func (d *Digest) verify(r *http.Request, params map[string]string) (string, string, error) {
+	user := params["username"]
+	switch {
+	case user == "":
+		return "", "missing username", nil
+	case params["realm"] != d.conf.Realm:
+		return "", fmt.Sprintf("wrong realm %q", params["realm"]), nil
+	case params["algorithm"] != "SHA-256":
+		return "", fmt.Sprintf("unsupported algorithm %q", params["algorithm"]), nil
+	case params["qop"] != "auth":
+		return "", fmt.Sprintf("unsupported qop %q", params["qop"]), nil
+	case params["uri"] != r.URL.RequestURI():
+		return "", fmt.Sprintf("uri %q does not match the request", params["uri"]), nil
+	case params["userhash"] == "true":
+		return "", "userhash is not supported", nil
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return "", "malformed nc", nil
+	}
+	if params["nonce"] == "" {
+		return "", "missing nonce", nil
+	}
+
+	ha1, err := d.conf.Secrets.HA1(user, d.conf.Realm)
+	if err != nil {
+		return "", "", err
+	}
+	if ha1 == "" {
+		return "", fmt.Sprintf("unknown user %s", user), nil
+	}
+	ha2 := sha256Hex(r.Method + ":" + params["uri"])
+	expected := sha256Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return "", fmt.Sprintf("wrong credentials for %s", user), nil
+	}
+
+	// A correct response to a nonce which was not issued by d, ie: before a
+	// restart or by another replica, proves the password: the client is
+	// asked to retry with a new nonce (RFC 7616, section 3.3).
+	issued, ok := d.checkNonce(params["nonce"])
+	if !ok || params["opaque"] != d.opaque {
+		return "", staleNonce, nil
+	}
+	now := d.now()
+	if !now.Before(issued.Add(d.conf.NonceTTL)) {
+		return "", staleNonce, nil
+	}
+	if !d.useCount(params["nonce"], nc, now) {
+		return "", fmt.Sprintf("replayed nonce count %s", params["nc"]), nil
+	}
+	return user, "", nil
+}
+
+// nonce returns a new nonce: the time it was issued, a random part and a MAC
+// of both.
+func (d *Digest) nonce(now time.Time) string {
+	b := make([]byte, 16, 48)
+	binary.BigEndian.PutUint64(b, uint64(now.UnixNano()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(b, d.mac(b)...))
+}
+
+// checkNonce reports whether the nonce was issued by d, and when.
+func (d *Digest) checkNonce(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 48 || !hmac.Equal(b[16:], d.mac(b[:16])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), true
+}
+
+func (d *Digest) mac(b []byte) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// useCount reports whether the nonce count is higher than the ones used
+// before with the nonce. The counts of expired nonces are forgotten.
+func (d *Digest) useCount(nonce string, nc uint64, now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Sub(d.swept) > d.conf.NonceTTL {
+		for n := range d.counts {
+			if issued, _ := d.checkNonce(n); !now.Before(issued.Add(d.conf.NonceTTL)) {
+				delete(d.counts, n)
+			}
+		}
+		d.swept = now
+	}
+	if nc <= d.counts[nonce] {
+		return false
+	}
+	d.counts[nonce] = nc
+	return true
+}
+
+// parseParams parses the comma separated auth-params of an Authorization
+// header, with quoted or token values.
+func parseParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			value, s = b.String(), s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[name] = value
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
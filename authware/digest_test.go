@@ -0,0 +1,152 @@
+package authware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// digestAuthorization answers the challenge like a client would.
+func digestAuthorization(challenge, method, uri, user, password string, nc int) string {
+	params := parseParams(strings.TrimPrefix(challenge, "Digest "))
+	ha1 := DigestHA1(user, params["realm"], password)
+	ha2 := sha256Hex(method + ":" + uri)
+	ncs := fmt.Sprintf("%08x", nc)
+	cnonce := "0a4f113b"
+	response := sha256Hex(strings.Join([]string{ha1, params["nonce"], ncs, cnonce, "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=SHA-256, qop=auth, nc=%s, cnonce="%s", response="%s", opaque="%s"`,
+		user, params["realm"], params["nonce"], uri, ncs, cnonce, response, params["opaque"])
+}
+
+func TestDigest(t *testing.T) {
+	now := time.Now()
+	d := NewDigest(DigestConfig{Realm: "api@example.com", Secrets: Passwords{"Mufasa": "Circle of Life"}})
+	d.now = func() time.Time { return now }
+	var user string
+	h := httpware.Compose(httpware.DefaultErrHandler, d).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		user = httpware.PrincipalFromCtx(ctx).Subject
+		return nil
+	})
+	serve := func(method, uri, authorization string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(method, uri, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := serve("GET", "/dir/index.html", "")
+	challenge := rec.Header().Get("WWW-Authenticate")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(challenge, `algorithm=SHA-256`) || !strings.Contains(challenge, `qop="auth"`) {
+		t.Fatalf("expected a digest challenge, got %v %q", rec.Code, challenge)
+	}
+
+	auth := digestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Life", 1)
+	if rec := serve("GET", "/dir/index.html", auth); rec.Code != http.StatusOK || user != "Mufasa" {
+		t.Fatalf("expected the request to be authenticated, got %v %q", rec.Code, user)
+	}
+	if rec := serve("GET", "/dir/index.html", auth); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a replayed nonce count to be rejected, got %v", rec.Code)
+	}
+	next := digestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Life", 2)
+	if rec := serve("GET", "/dir/index.html", next); rec.Code != http.StatusOK {
+		t.Fatalf("expected the next nonce count to be accepted, got %v", rec.Code)
+	}
+	if rec := serve("GET", "/other", next); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a mismatched uri to be rejected, got %v", rec.Code)
+	}
+	wrong := digestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Death", 3)
+	if rec := serve("GET", "/dir/index.html", wrong); rec.Code != http.StatusUnauthorized || strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale") {
+		t.Fatalf("expected a wrong password to be rejected, got %v", rec.Code)
+	}
+
+	now = now.Add(time.Hour)
+	stale := digestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Life", 4)
+	rec = serve("GET", "/dir/index.html", stale)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("expected a stale challenge, got %v %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	retry := digestAuthorization(rec.Header().Get("WWW-Authenticate"), "GET", "/dir/index.html", "Mufasa", "Circle of Life", 1)
+	if rec := serve("GET", "/dir/index.html", retry); rec.Code != http.StatusOK {
+		t.Fatalf("expected the retry with the new nonce to be accepted, got %v", rec.Code)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	params := parseParams(`username="Mufasa", realm="a \"quoted\" realm",nc=00000001, qop=auth`)
+	expected := map[string]string{"username": "Mufasa", "realm": `a "quoted" realm`, "nc": "00000001", "qop": "auth"}
+	for k, v := range expected {
+		if params[k] != v {
+			t.Fatalf("expected %s=%q, got %q", k, v, params[k])
+		}
+	}
+}
+
+func TestDigestForeignNonce(t *testing.T) {
+	secrets := Passwords{"Mufasa": "Circle of Life"}
+	serve := func(d *Digest, authorization string) *httptest.ResponseRecorder {
+		h := httpware.Compose(httpware.DefaultErrHandler, d).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// Replicas sharing a key accept each other's nonces.
+	key := []byte("shared secret")
+	a := NewDigest(DigestConfig{Secrets: secrets, Key: key})
+	b := NewDigest(DigestConfig{Secrets: secrets, Key: key})
+	challenge := serve(a, "").Header().Get("WWW-Authenticate")
+	if rec := serve(b, digestAuthorization(challenge, "GET", "/", "Mufasa", "Circle of Life", 1)); rec.Code != http.StatusOK {
+		t.Fatalf("expected the nonce of a replica to be accepted, got %v", rec.Code)
+	}
+
+	// After a restart with a new key, a correct response is answered with a
+	// stale challenge, a wrong one is not.
+	restarted := NewDigest(DigestConfig{Secrets: secrets})
+	rec := serve(restarted, digestAuthorization(challenge, "GET", "/", "Mufasa", "Circle of Life", 2))
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("expected a stale challenge, got %v %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	rec = serve(restarted, digestAuthorization(challenge, "GET", "/", "Mufasa", "wrong", 3))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Header().Get("WWW-Authenticate"), "stale") {
+		t.Fatalf("expected a wrong password to be rejected, got %v %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+}
+
+type failingSecrets struct{}
+
+func (failingSecrets) HA1(user, realm string) (string, error) {
+	return "", errors.New("backend is down")
+}
+
+func TestDigestSecretsFailure(t *testing.T) {
+	d := NewDigest(DigestConfig{Secrets: failingSecrets{}})
+	h := httpware.Compose(httpware.DefaultErrHandler, d).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", digestAuthorization(d.Challenge(httpware.ErrNoCredentials), "GET", "/", "Mufasa", "Circle of Life", 1))
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the error of the secrets to be returned, got %v", rec.Code)
+	}
+	if ch := rec.Header().Get("WWW-Authenticate"); ch != "" {
+		t.Fatalf("expected no challenge, got %q", ch)
+	}
+}
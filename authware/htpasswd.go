@@ -0,0 +1,182 @@
+package authware
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd is a Verifier which checks the passwords of an htpasswd file, as
+// written by Apache's htpasswd tool. Entries hashed with bcrypt ("$2y$"),
+// apr1 ("$apr1$") and SHA-1 ("{SHA}") are supported; bcrypt should be
+// preferred, the others are weak.
+type Htpasswd struct {
+	path string
+
+	mutex   sync.RWMutex
+	entries map[string]string
+}
+
+// LoadHtpasswd reads the htpasswd file at path.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload reads the file again, ie: after users were added. The entries which
+// were read before remain in use if it fails.
+func (h *Htpasswd) Reload() error {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := parseHtpasswd(f)
+	if err != nil {
+		return fmt.Errorf("authware: reading %s: %v", h.path, err)
+	}
+	h.mutex.Lock()
+	h.entries = entries
+	h.mutex.Unlock()
+	return nil
+}
+
+// ParseHtpasswd returns a Htpasswd with the entries read from r.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	entries, err := parseHtpasswd(r)
+	if err != nil {
+		return nil, fmt.Errorf("authware: reading htpasswd: %v", err)
+	}
+	return &Htpasswd{entries: entries}, nil
+}
+
+func parseHtpasswd(r io.Reader) (map[string]string, error) {
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("line %v: missing user", n)
+		}
+		hash := line[colon+1:]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "$apr1$") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("line %v: unsupported hash", n)
+		}
+		entries[line[:colon]] = hash
+	}
+	return entries, scanner.Err()
+}
+
+// Verify fulfills the Verifier interface.
+func (h *Htpasswd) Verify(user, password string) (bool, error) {
+	h.mutex.RLock()
+	hash, ok := h.entries[user]
+	h.mutex.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.TrimPrefix(hash, "$apr1$")
+		if i := strings.IndexByte(salt, '$'); i >= 0 {
+			salt = salt[:i]
+		}
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1, nil
+	default:
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1, nil
+	}
+}
+
+// apr1 returns the Apache variant of the MD5-based crypt of the password.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic))
+	h.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(altSum)
+		} else {
+			h.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out strings.Builder
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return magic + salt + "$" + out.String()
+}
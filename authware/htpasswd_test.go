@@ -0,0 +1,58 @@
+package authware
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := strings.Join([]string{
+		"# users",
+		"bob:" + string(hash),
+		"carol:$apr1$saltsalt$PJb4W8ntWxx8aGv5c1OgQ0",
+		"dave:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"",
+	}, "\n")
+	h, err := ParseHtpasswd(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		User     string
+		Password string
+		Valid    bool
+	}{
+		{"bob", "bcrypt-pass", true},
+		{"bob", "wrong", false},
+		{"carol", "p@ss w0rd", true},
+		{"carol", "p@ss w0rd!", false},
+		{"dave", "secret", true},
+		{"dave", "Secret", false},
+		{"erin", "secret", false},
+	}
+	for _, c := range cases {
+		valid, err := h.Verify(c.User, c.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.Valid {
+			t.Fatalf("%s/%s: expected %v, got %v", c.User, c.Password, c.Valid, valid)
+		}
+	}
+
+	if _, err := ParseHtpasswd(strings.NewReader("eve:plaintext\n")); err == nil {
+		t.Fatal("expected an error for an unsupported hash")
+	}
+}
+
+func TestApr1(t *testing.T) {
+	if hash := apr1("secret", "r31....."); hash != "$apr1$r31.....$G/cElGhD0cboYkZN5h5Ne/" {
+		t.Fatalf("unexpected hash: %v", hash)
+	}
+}
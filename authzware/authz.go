@@ -0,0 +1,127 @@
+/*
+Package authzware provides authorization middleware. It checks the
+httpware.Principal stored in the context by authentication middleware such as
+tokenware. The middleware can be composed per route, ie:
+
+	httpware.Compose(errWare,
+		tokenware.New(tokenConf),
+		authzware.RequireScopes("orders:write"),
+		authzware.ParamMatchesClaim("userID", "sub"),
+	)
+
+Requests without a principal get a 401 (Unauthorized) response, requests
+which are not permitted get a 403 (Forbidden) response whose "permission"
+field names what is missing.
+*/
+package authzware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nstogner/httpware"
+)
+
+// Predicate decides whether the principal may make the request.
+type Predicate func(ctx context.Context, r *http.Request, p *httpware.Principal) bool
+
+// Require returns middleware which only lets requests through for which pred
+// returns true. The permission is named in the error of rejected requests.
+func Require(permission string, pred Predicate) httpware.Middleware {
+	return check(func(ctx context.Context, r *http.Request, p *httpware.Principal) error {
+		if pred(ctx, r, p) {
+			return nil
+		}
+		return forbidden(permission)
+	})
+}
+
+// RequireScopes returns middleware which requires all of the scopes. The
+// missing scopes are listed in the "missingScopes" field of the error.
+func RequireScopes(scopes ...string) httpware.Middleware {
+	return check(func(ctx context.Context, r *http.Request, p *httpware.Principal) error {
+		var missing []string
+		for _, s := range scopes {
+			if !p.HasScope(s) {
+				missing = append(missing, s)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		return forbidden("scope:"+strings.Join(missing, " ")).WithField("missingScopes", missing)
+	})
+}
+
+// RequireAnyRole returns middleware which requires at least one of the roles.
+func RequireAnyRole(roles ...string) httpware.Middleware {
+	return check(func(ctx context.Context, r *http.Request, p *httpware.Principal) error {
+		for _, role := range roles {
+			if p.HasRole(role) {
+				return nil
+			}
+		}
+		return forbidden("role:" + strings.Join(roles, "|"))
+	})
+}
+
+// RequireRoles returns middleware which requires all of the roles.
+func RequireRoles(roles ...string) httpware.Middleware {
+	return check(func(ctx context.Context, r *http.Request, p *httpware.Principal) error {
+		for _, role := range roles {
+			if !p.HasRole(role) {
+				return forbidden("role:" + role)
+			}
+		}
+		return nil
+	})
+}
+
+// ParamMatchesClaim returns middleware which requires the httprouter
+// parameter to equal the claim of the principal, ie: ParamMatchesClaim(
+// "userID", "sub") lets users only access "/users/:userID" for themselves.
+// The "sub" claim falls back to Principal.Subject.
+func ParamMatchesClaim(param, claim string) httpware.Middleware {
+	return Require(fmt.Sprintf("%s=:%s", claim, param), func(ctx context.Context, r *http.Request, p *httpware.Principal) bool {
+		ps, _ := ctx.Value(httpware.RouterParamsKey).(httprouter.Params)
+		value := ps.ByName(param)
+		if value == "" {
+			return false
+		}
+		if c, ok := p.Claims[claim]; ok {
+			return fmt.Sprint(c) == value
+		}
+		return claim == "sub" && p.Subject == value
+	})
+}
+
+// check returns middleware which calls f with the principal of the request.
+func check(f func(ctx context.Context, r *http.Request, p *httpware.Principal) error) httpware.Middleware {
+	return middleware(func(next httpware.Handler) httpware.Handler {
+		return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			p := httpware.PrincipalFromCtx(ctx)
+			if p == nil {
+				return httpware.NewErr("authentication required", http.StatusUnauthorized)
+			}
+			if err := f(ctx, r, p); err != nil {
+				return err
+			}
+			return next.ServeHTTPCtx(ctx, w, r)
+		})
+	})
+}
+
+func forbidden(permission string) httpware.Err {
+	return httpware.NewErr("insufficient permissions", http.StatusForbidden).WithField("permission", permission)
+}
+
+// middleware is an adapter to allow the use of ordinary functions as
+// httpware.Middleware.
+type middleware func(httpware.Handler) httpware.Handler
+
+func (f middleware) Handle(next httpware.Handler) httpware.Handler {
+	return f(next)
+}
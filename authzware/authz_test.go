@@ -0,0 +1,70 @@
+package authzware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nstogner/httpware"
+)
+
+func TestAuthorization(t *testing.T) {
+	alice := &httpware.Principal{
+		Subject: "alice",
+		Scopes:  []string{"orders:read", "orders:write"},
+		Roles:   []string{"support"},
+		Claims:  map[string]interface{}{"sub": "alice", "tenant": "acme"},
+	}
+	cases := []struct {
+		Name       string
+		Middleware httpware.Middleware
+		Principal  *httpware.Principal
+		Params     httprouter.Params
+		Status     int
+		Permission string
+	}{
+		{"anonymous", RequireScopes("orders:read"), nil, nil, http.StatusUnauthorized, ""},
+		{"scopes", RequireScopes("orders:read", "orders:write"), alice, nil, http.StatusOK, ""},
+		{"missing scope", RequireScopes("orders:read", "orders:delete"), alice, nil, http.StatusForbidden, "scope:orders:delete"},
+		{"any role", RequireAnyRole("admin", "support"), alice, nil, http.StatusOK, ""},
+		{"no role", RequireAnyRole("admin", "billing"), alice, nil, http.StatusForbidden, "role:admin|billing"},
+		{"all roles", RequireRoles("support", "admin"), alice, nil, http.StatusForbidden, "role:admin"},
+		{"own user", ParamMatchesClaim("userID", "sub"), alice, httprouter.Params{{Key: "userID", Value: "alice"}}, http.StatusOK, ""},
+		{"other user", ParamMatchesClaim("userID", "sub"), alice, httprouter.Params{{Key: "userID", Value: "bob"}}, http.StatusForbidden, "sub=:userID"},
+		{"other tenant", ParamMatchesClaim("tenant", "tenant"), alice, httprouter.Params{{Key: "tenant", Value: "initech"}}, http.StatusForbidden, "tenant=:tenant"},
+		{"predicate", Require("weekday", func(ctx context.Context, r *http.Request, p *httpware.Principal) bool {
+			return r.URL.Query().Get("day") != "sunday"
+		}), alice, nil, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		h := httpware.Compose(httpware.DefaultErrHandler, c.Middleware).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+		ctx := context.WithValue(context.Background(), httpware.RouterParamsKey, c.Params)
+		if c.Principal != nil {
+			ctx = context.WithValue(ctx, httpware.PrincipalKey, c.Principal)
+		}
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", "application/json")
+		h.ServeHTTPCtx(ctx, rec, r)
+		if rec.Code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, rec.Code)
+		}
+		if c.Permission == "" {
+			continue
+		}
+		var body struct {
+			Fields struct {
+				Permission string `json:"permission"`
+			} `json:"fields"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Fields.Permission != c.Permission {
+			t.Fatalf("%s: expected permission %q, got %q", c.Name, c.Permission, body.Fields.Permission)
+		}
+	}
+}
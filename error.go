@@ -6,6 +6,9 @@ type Err struct {
 	StatusCode int                    `json:"-" xml:"-"`
 	Message    string                 `json:"message" xml:"message"`
 	Fields     map[string]interface{} `json:"fields,omitempty" xml:"fields,omitempty"`
+	// RequestID is set by ErrHandler from the response header, see
+	// ErrHandlerConfig.RequestIDHeader.
+	RequestID string `json:"requestId,omitempty" xml:"requestId,omitempty"`
 }
 
 // NewErr creates an bare minimum http error.
@@ -25,6 +28,7 @@ func (err Err) WithField(name string, value interface{}) Err {
 		StatusCode: err.StatusCode,
 		Message:    err.Message,
 		Fields:     err.Fields,
+		RequestID:  err.RequestID,
 	}
 }
 
@@ -24,6 +24,10 @@ type ErrHandlerConfig struct {
 	// To allow >500 code responses to contain errors, set this to false.
 	Suppress500Messages bool
 	CatchPanics         bool
+	// The response header which holds the request ID, ie: as set by
+	// reqidware. Its value is included in error bodies as "requestId".
+	// Defaults to "X-Request-ID".
+	RequestIDHeader string
 }
 
 // ErrHandler is an implementation of Errware. It handles any errors that are
@@ -37,6 +41,9 @@ type ErrHandler struct {
 
 // NewErrHandler returns a new instance of ErrHandler.
 func NewErrHandler(conf ErrHandlerConfig) *ErrHandler {
+	if conf.RequestIDHeader == "" {
+		conf.RequestIDHeader = "X-Request-ID"
+	}
 	return &ErrHandler{
 		conf: conf,
 	}
@@ -48,7 +55,9 @@ func (h *ErrHandler) HandleErr(next Handler) Handler {
 		if h.conf.CatchPanics {
 			defer func() {
 				if rcv := recover(); rcv != nil {
-					writeErr(w, NewErr(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError))
+					respErr := NewErr(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					respErr.RequestID = w.Header().Get(h.conf.RequestIDHeader)
+					writeErr(w, respErr)
 				}
 			}()
 		}
@@ -80,12 +89,26 @@ func (h *ErrHandler) HandleErr(next Handler) Handler {
 					respErr.Message = err.Error()
 				}
 			}
+			respErr.RequestID = w.Header().Get(h.conf.RequestIDHeader)
 			writeErr(w, respErr)
 		}
 		return err
 	})
 }
 
+// StatusOf returns the status code which an ErrHandler writes for the error
+// returned by a handler: 200 for nil, the StatusCode of an Err and 500 for any
+// other error.
+func StatusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if httpErr, ok := err.(Err); ok {
+		return httpErr.StatusCode
+	}
+	return http.StatusInternalServerError
+}
+
 // writeErr writes a reponses code and populates the body.
 func writeErr(w http.ResponseWriter, err Err) {
 	w.WriteHeader(err.StatusCode)
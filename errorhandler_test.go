@@ -2,6 +2,7 @@ package httpware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -52,3 +53,39 @@ func TestErrorHandler(t *testing.T) {
 		t.Fatalf("expected response body: %s, got: %s", expected, got)
 	}
 }
+
+func TestErrorHandlerRequestID(t *testing.T) {
+	hdlr := Compose(DefaultErrHandler).Then(
+		HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("X-Request-ID", "req-1")
+			return NewErr("better luck next time", http.StatusBadRequest)
+		}),
+	)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://testing/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	hdlr.ServeHTTP(rec, req)
+	expected := `{"message":"better luck next time","requestId":"req-1"}` + "\n"
+	if got := rec.Body.String(); got != expected {
+		t.Fatalf("expected body: %s, got: %s", expected, got)
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{NewErr("not found", http.StatusNotFound), http.StatusNotFound},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if s := StatusOf(c.err); s != c.status {
+			t.Errorf("expected %v for %v, got %v", c.status, c.err, s)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Package keyware provides middleware for authenticating machine clients with
+long-lived, opaque API keys. A key looks like:
+
+	hw_live_3f9c1a0b7d2e4c6f_Jq8v...
+
+It consists of a prefix, which identifies the kind of key (and makes leaked
+keys easy to scan for), the ID of the key and its secret. Only a hash of the
+key is stored, see Store. The middleware implements the
+httpware.Authenticator interface, so it can accept either JWTs or API keys:
+
+	httpware.Compose(errWare, httpware.AnyOf(keyMiddle, tokenMiddle))
+
+It goes first, as it only claims bearer tokens with its prefix while
+tokenware claims any bearer token.
+*/
+package keyware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// CodeInvalidKey is the "code" of errors for rejected API keys.
+const CodeInvalidKey = "invalid_api_key"
+
+// Config is used to initialize a new instance of this middleware.
+type Config struct {
+	// The prefix of the keys, ie: "hw_live_". Required.
+	Prefix string
+	// Holds the hashed keys. Required.
+	Store Store
+	// When set, keys are hashed with HMAC-SHA256 using this secret instead
+	// of plain SHA-256, so that the hashes of a leaked store can not be
+	// checked without it.
+	Secret []byte
+	// The header which carries the key. Defaults to "X-API-Key". Keys are
+	// also accepted as a bearer token in the Authorization header.
+	Header string
+}
+
+// KeyFromCtx retrieves the API key which authenticated the request. It returns
+// nil for requests which were authenticated otherwise.
+func KeyFromCtx(ctx context.Context) *APIKey {
+	k, _ := ctx.Value(httpware.APIKeyKey).(*APIKey)
+	return k
+}
+
+// Middle verifies the API key of the request. It will return an
+// 'Unauthorized' response if the key is missing or invalid. The client of a
+// valid key is stored in the context as an httpware.Principal, with the
+// scopes and roles of the key.
+type Middle struct {
+	conf Config
+}
+
+// New returns a new instance of the middleware. It panics if Config.Prefix or
+// Config.Store is missing.
+func New(conf Config) *Middle {
+	if conf.Prefix == "" || conf.Store == nil {
+		panic("keyware: Config.Prefix and Config.Store are required")
+	}
+	if conf.Header == "" {
+		conf.Header = "X-API-Key"
+	}
+	return &Middle{conf: conf}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		newCtx, err := m.Authenticate(ctx, r)
+		if err == httpware.ErrNoCredentials {
+			w.Header().Set("WWW-Authenticate", m.Challenge(err))
+			return httpware.NewErr("missing API key", http.StatusUnauthorized).WithField("code", CodeInvalidKey)
+		}
+		if httpware.StatusOf(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", m.Challenge(err))
+		}
+		if err != nil {
+			return err
+		}
+		return next.ServeHTTPCtx(newCtx, w, r)
+	})
+}
+
+// Authenticate fulfills the httpware.Authenticator interface. Bearer tokens
+// without the prefix, ie: JWTs, are not considered API keys.
+func (m *Middle) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	raw := r.Header.Get(m.conf.Header)
+	if raw == "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") && strings.HasPrefix(auth[7:], m.conf.Prefix) {
+			raw = auth[7:]
+		}
+	}
+	if raw == "" {
+		return ctx, httpware.ErrNoCredentials
+	}
+
+	k, reason, err := m.verify(raw)
+	if err != nil {
+		// The store is unavailable, the key may well be valid. The details
+		// are logged rather than sent to the client.
+		logware.LoggerFromCtx(ctx).AddField("keyError", err.Error())
+		return ctx, httpware.NewErr("API key lookup failed", http.StatusInternalServerError)
+	}
+	if k == nil {
+		logware.LoggerFromCtx(ctx).AddField("keyError", reason)
+		return ctx, httpware.NewErr("invalid API key", http.StatusUnauthorized).WithField("code", CodeInvalidKey)
+	}
+	logware.LoggerFromCtx(ctx).AddField("principal", k.Subject)
+	p := &httpware.Principal{
+		Subject: k.Subject,
+		Scopes:  k.Scopes,
+		Roles:   k.Roles,
+		Claims:  map[string]interface{}{"sub": k.Subject, "keyId": k.ID},
+	}
+	ctx = context.WithValue(ctx, httpware.APIKeyKey, k)
+	return context.WithValue(ctx, httpware.PrincipalKey, p), nil
+}
+
+// Challenge fulfills the httpware.Authenticator interface. Only rejected keys
+// are flagged as invalid, not failures of the Store.
+func (m *Middle) Challenge(err error) string {
+	if e, ok := err.(httpware.Err); ok && e.StatusCode == http.StatusUnauthorized {
+		return `Bearer error="invalid_token"`
+	}
+	return "Bearer"
+}
+
+// verify returns the stored key which matches raw, or the reason why there is
+// none. Errors of the Store are returned with the ID of the key.
+func (m *Middle) verify(raw string) (*APIKey, string, error) {
+	id, ok := m.parse(raw)
+	if !ok {
+		return nil, "malformed key", nil
+	}
+	k, err := m.conf.Store.LookupKey(id)
+	if err == ErrKeyNotFound {
+		return nil, "unknown key " + id, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("keyware: looking up key %s: %v", id, err)
+	}
+	if subtle.ConstantTimeCompare(m.hash(raw), k.Hash) != 1 {
+		return nil, "wrong secret for key " + id, nil
+	}
+	if !k.Expires.IsZero() && !time.Now().Before(k.Expires) {
+		return nil, "key " + id + " expired", nil
+	}
+	return &k, "", nil
+}
+
+// parse returns the ID of the key.
+func (m *Middle) parse(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, m.conf.Prefix) {
+		return "", false
+	}
+	rest := raw[len(m.conf.Prefix):]
+	i := strings.IndexByte(rest, '_')
+	if i <= 0 || i == len(rest)-1 {
+		return "", false
+	}
+	return rest[:i], true
+}
+
+func (m *Middle) hash(raw string) []byte {
+	if len(m.conf.Secret) == 0 {
+		sum := sha256.Sum256([]byte(raw))
+		return sum[:]
+	}
+	mac := hmac.New(sha256.New, m.conf.Secret)
+	mac.Write([]byte(raw))
+	return mac.Sum(nil)
+}
+
+// NewKey generates a new API key. The returned APIKey, which holds the ID and
+// hash of the key but not the key itself, is to be saved in the Store after
+// setting its Subject, Scopes and Roles. The key is shown to its owner once,
+// it can not be recovered.
+func (m *Middle) NewKey() (string, APIKey) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	raw := m.conf.Prefix + hex.EncodeToString(id) + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return raw, APIKey{ID: hex.EncodeToString(id), Hash: m.hash(raw)}
+}
@@ -0,0 +1,141 @@
+package keyware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/tokenware"
+)
+
+func TestWare(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(Config{Prefix: "hw_live_", Store: store, Secret: []byte("pepper")})
+	key, k := m.NewKey()
+	k.Subject = "billing"
+	k.Scopes = []string{"invoices:read"}
+	store.Add(k)
+	expired, e := m.NewKey()
+	e.Expires = time.Now().Add(-time.Minute)
+	store.Add(e)
+	revoked, _ := m.NewKey()
+
+	var p *httpware.Principal
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p = httpware.PrincipalFromCtx(ctx)
+		if KeyFromCtx(ctx).ID != k.ID {
+			t.Fatal("expected the key in the context")
+		}
+		return nil
+	})
+
+	cases := []struct {
+		Name   string
+		Header string
+		Value  string
+		Status int
+	}{
+		{"header", "X-API-Key", key, http.StatusOK},
+		{"bearer", "Authorization", "Bearer " + key, http.StatusOK},
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong secret", "X-API-Key", key[:len(key)-2] + "xx", http.StatusUnauthorized},
+		{"wrong prefix", "X-API-Key", "hw_test_" + key[len("hw_live_"):], http.StatusUnauthorized},
+		{"malformed", "X-API-Key", "hw_live_nosecret", http.StatusUnauthorized},
+		{"expired", "X-API-Key", expired, http.StatusUnauthorized},
+		{"unknown", "X-API-Key", revoked, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		p = nil
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.Header != "" {
+			r.Header.Set(c.Header, c.Value)
+		}
+		h.ServeHTTP(rec, r)
+		if rec.Code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, rec.Code)
+		}
+		if c.Status == http.StatusOK && (p == nil || p.Subject != "billing" || !p.HasScope("invoices:read")) {
+			t.Fatalf("%s: unexpected principal: %+v", c.Name, p)
+		}
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	secret := []byte("shh")
+	store := NewMemoryStore()
+	keys := New(Config{Prefix: "hw_live_", Store: store})
+	key, k := keys.NewKey()
+	k.Subject = "billing"
+	store.Add(k)
+	tokens := tokenware.New(tokenware.Config{Algorithms: []string{"HS256"}, Secret: secret})
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice"}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subject string
+	h := httpware.Compose(httpware.DefaultErrHandler, httpware.AnyOf(keys, tokens)).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		subject = httpware.PrincipalFromCtx(ctx).Subject
+		return nil
+	})
+	cases := []struct {
+		Name          string
+		Authorization string
+		Status        int
+		Subject       string
+	}{
+		{"jwt", "Bearer " + token, http.StatusOK, "alice"},
+		{"api key", "Bearer " + key, http.StatusOK, "billing"},
+		{"invalid jwt", "Bearer " + token + "x", http.StatusUnauthorized, ""},
+		{"none", "", http.StatusUnauthorized, ""},
+	}
+	for _, c := range cases {
+		subject = ""
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.Authorization != "" {
+			r.Header.Set("Authorization", c.Authorization)
+		}
+		h.ServeHTTP(rec, r)
+		if rec.Code != c.Status || subject != c.Subject {
+			t.Fatalf("%s: expected %v %q, got %v %q", c.Name, c.Status, c.Subject, rec.Code, subject)
+		}
+		if c.Status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: expected a challenge", c.Name)
+		}
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) LookupKey(id string) (APIKey, error) {
+	return APIKey{}, errors.New("connection refused")
+}
+
+func TestStoreFailure(t *testing.T) {
+	m := New(Config{Prefix: "hw_live_", Store: failingStore{}})
+	key, _ := m.NewKey()
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", key)
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code %v, got %v", http.StatusInternalServerError, rec.Code)
+	}
+	if ch := rec.Header().Get("WWW-Authenticate"); ch != "" {
+		t.Fatalf("expected no challenge, got %q", ch)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "connection refused") || strings.Contains(body, "hw_live_") {
+		t.Fatalf("expected the details of the failure not to be sent, got %s", body)
+	}
+}
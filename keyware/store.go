@@ -0,0 +1,75 @@
+package keyware
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned by a Store which has no key with the requested
+// ID.
+var ErrKeyNotFound = errors.New("keyware: key not found")
+
+// APIKey is an API key as it is stored.
+type APIKey struct {
+	// The ID part of the key.
+	ID string
+	// The hash of the whole key, see Config.Secret.
+	Hash []byte
+	// The owner of the key, ie: the name of a service.
+	Subject string
+	// The permissions granted to the key.
+	Scopes []string
+	Roles  []string
+	// A zero time never expires.
+	Expires time.Time
+}
+
+// Store holds the API keys.
+type Store interface {
+	// LookupKey returns the key with the given ID, or ErrKeyNotFound. Other
+	// errors fail the request with a 500 (Internal Server Error) response
+	// rather than rejecting the key.
+	LookupKey(id string) (APIKey, error)
+}
+
+// MemoryStore is a Store which keeps the keys in the memory of the current
+// process, ie: loaded from the configuration at startup.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	keys  map[string]APIKey
+}
+
+// NewMemoryStore returns a new MemoryStore with the given keys.
+func NewMemoryStore(keys ...APIKey) *MemoryStore {
+	s := &MemoryStore{keys: make(map[string]APIKey)}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return s
+}
+
+// LookupKey fulfills the Store interface.
+func (s *MemoryStore) LookupKey(id string) (APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return k, nil
+}
+
+// Add adds or replaces a key.
+func (s *MemoryStore) Add(k APIKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys[k.ID] = k
+}
+
+// Remove revokes the key with the given ID.
+func (s *MemoryStore) Remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.keys, id)
+}
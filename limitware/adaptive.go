@@ -0,0 +1,463 @@
+package limitware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+var (
+	// AdaptiveDefaults is a reasonable configuration.
+	AdaptiveDefaults = AdaptiveConfig{
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     1000,
+		RetryAfter:   1,
+	}
+)
+
+// Algorithm decides the limit of concurrent requests based on the latency of
+// the requests which were served. Update is called after every request while
+// holding a lock, so implementations do not need to be safe for concurrent
+// use.
+type Algorithm interface {
+	// Update returns the new limit. The rtt is the latency of the request,
+	// inflight is the number of requests that were in flight when it was
+	// admitted and dropped reports whether the request failed with a server
+	// error.
+	Update(limit int, rtt time.Duration, inflight int, dropped bool) int
+}
+
+// AIMD is an additive-increase/multiplicative-decrease Algorithm. The limit
+// grows by one while requests succeed and the limit is being used, and is
+// multiplied by Backoff when a request is dropped or takes longer than
+// Timeout.
+type AIMD struct {
+	// Defaults to 0.9.
+	Backoff float64
+	// Requests slower than this are treated as dropped. Zero disables the
+	// timeout.
+	Timeout time.Duration
+}
+
+// Update fulfills the Algorithm interface.
+func (a *AIMD) Update(limit int, rtt time.Duration, inflight int, dropped bool) int {
+	if dropped || (a.Timeout > 0 && rtt > a.Timeout) {
+		backoff := a.Backoff
+		if backoff == 0 {
+			backoff = 0.9
+		}
+		return int(float64(limit) * backoff)
+	}
+	if inflight*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Vegas is an Algorithm based on TCP Vegas. It estimates the size of the
+// queue from the ratio between the lowest latency seen (the latency without
+// load) and the latency of each request, and keeps it between Alpha and
+// Beta.
+type Vegas struct {
+	// Defaults to 3.
+	Alpha int
+	// Defaults to 6.
+	Beta int
+
+	minRTT time.Duration
+}
+
+// Update fulfills the Algorithm interface.
+func (v *Vegas) Update(limit int, rtt time.Duration, inflight int, dropped bool) int {
+	if rtt <= 0 {
+		return limit
+	}
+	if v.minRTT == 0 || rtt < v.minRTT {
+		v.minRTT = rtt
+	}
+	alpha, beta := v.Alpha, v.Beta
+	if alpha == 0 {
+		alpha = 3
+	}
+	if beta == 0 {
+		beta = 6
+	}
+	step := int(math.Max(1, math.Log10(float64(limit))))
+	if dropped {
+		return limit - step
+	}
+	queue := int(math.Ceil(float64(limit) * (1 - float64(v.minRTT)/float64(rtt))))
+	switch {
+	case queue < alpha && inflight*2 >= limit:
+		return limit + step
+	case queue > beta:
+		return limit - step
+	}
+	return limit
+}
+
+// Gradient is an Algorithm which compares the latency of each request to a
+// long term (exponentially smoothed) average. The limit shrinks as requests
+// become slower than the average, and grows by the square root of the limit
+// otherwise.
+type Gradient struct {
+	// How quickly the limit changes. Defaults to 0.2.
+	Smoothing float64
+	// How much slower than the average requests may be before the limit is
+	// reduced. Defaults to 1.5.
+	Tolerance float64
+
+	longRTT float64
+}
+
+// Update fulfills the Algorithm interface.
+func (g *Gradient) Update(limit int, rtt time.Duration, inflight int, dropped bool) int {
+	if rtt <= 0 {
+		return limit
+	}
+	smoothing, tolerance := g.Smoothing, g.Tolerance
+	if smoothing == 0 {
+		smoothing = 0.2
+	}
+	if tolerance == 0 {
+		tolerance = 1.5
+	}
+	if g.longRTT == 0 {
+		g.longRTT = float64(rtt)
+	} else {
+		g.longRTT = g.longRTT*0.99 + float64(rtt)*0.01
+	}
+	if inflight*2 < limit && !dropped {
+		// The limit is not being tested, so there is nothing to learn.
+		return limit
+	}
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.longRTT/float64(rtt)))
+	if dropped {
+		gradient = 0.5
+	}
+	next := float64(limit)*gradient + math.Sqrt(float64(limit))
+	return int(math.Round(float64(limit)*(1-smoothing) + next*smoothing))
+}
+
+// AdaptiveConfig is used to initialize a new instance of Adaptive.
+type AdaptiveConfig struct {
+	// Defaults to AIMD.
+	Algorithm Algorithm
+	// The limit used before any latency has been measured.
+	InitialLimit int
+	// Bounds for the limit.
+	MinLimit int
+	MaxLimit int
+	// The number of requests which can wait for capacity before being
+	// shed. Zero rejects requests as soon as the limit is reached.
+	QueueSize int
+	// How long a request can wait in the queue. Defaults to a second.
+	QueueTimeout time.Duration
+	// Sets the header 'Retry-After' on shed requests. Units are in seconds.
+	RetryAfter int
+	// The priority classes, highest priority first. Defaults to a single
+	// class.
+	Classes []Class
+	// Classify assigns each request to one of the Classes and to a tenant.
+	// By default all requests share the first class and a single tenant.
+	Classify func(*http.Request) Classification
+}
+
+// Class is a priority class of requests.
+type Class struct {
+	Name string
+	// The number of slots of the limit which are reserved for this class and
+	// the classes above it. Requests from lower priority classes are only
+	// admitted while more than the reserved number of slots are free.
+	Reserved int
+	// The credit a tenant of this class receives on each of its turns in the
+	// queue. Defaults to 1.
+	Quantum int
+}
+
+// Classification is the result of classifying a request.
+type Classification struct {
+	// Index into AdaptiveConfig.Classes. Out of range values are treated as
+	// the lowest priority class.
+	Class int
+	// Tenants of a class take fair turns in the queue, so that a single
+	// tenant cannot starve the others.
+	Tenant string
+	// How much of its tenant's turn the request uses up while queued.
+	// Defaults to 1.
+	Cost int
+}
+
+// Adaptive is middleware which limits the number of concurrent requests to a
+// limit that adapts to the measured latency. Requests that exceed the limit
+// wait in a queue or are shed with a 503 (Service Unavailable) response.
+//
+// Queued requests are admitted by priority class. Within a class the tenants
+// take turns (deficit round robin), and the requests of a single tenant are
+// admitted in LIFO order: the most recent requests are served first, as their
+// clients are the most likely to still be waiting. When the queue is full
+// the oldest request of the lowest priority class is shed.
+type Adaptive struct {
+	conf AdaptiveConfig
+	// The number of slots reserved by the classes above each class.
+	reservedAbove []int
+
+	mutex    sync.Mutex
+	limit    int
+	inflight int
+	queues   []*classQueue
+	queued   int
+	seq      uint64
+
+	// The number of shed requests, accessed atomically.
+	shed uint64
+}
+
+// NewAdaptive returns a new instance of Adaptive.
+func NewAdaptive(conf AdaptiveConfig) *Adaptive {
+	if conf.Algorithm == nil {
+		conf.Algorithm = &AIMD{}
+	}
+	if conf.MinLimit <= 0 {
+		conf.MinLimit = 1
+	}
+	if conf.MaxLimit < conf.MinLimit {
+		conf.MaxLimit = conf.MinLimit
+	}
+	if conf.InitialLimit < conf.MinLimit {
+		conf.InitialLimit = conf.MinLimit
+	}
+	if conf.InitialLimit > conf.MaxLimit {
+		conf.InitialLimit = conf.MaxLimit
+	}
+	if conf.QueueTimeout == 0 {
+		conf.QueueTimeout = time.Second
+	}
+	if len(conf.Classes) == 0 {
+		conf.Classes = []Class{{Name: "default"}}
+	}
+	a := &Adaptive{
+		conf:  conf,
+		limit: conf.InitialLimit,
+	}
+	reserved := 0
+	for _, c := range conf.Classes {
+		a.reservedAbove = append(a.reservedAbove, reserved)
+		reserved += c.Reserved
+		quantum := c.Quantum
+		if quantum <= 0 {
+			quantum = 1
+		}
+		a.queues = append(a.queues, newClassQueue(quantum))
+	}
+	return a
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (a *Adaptive) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		inflight, ok := a.acquire(ctx, a.classify(r))
+		if !ok {
+			atomic.AddUint64(&a.shed, 1)
+			if a.conf.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(a.conf.RetryAfter))
+			}
+			return httpware.NewErr("server is overloaded", http.StatusServiceUnavailable)
+		}
+
+		start := time.Now()
+		// A panic counts as a dropped request.
+		dropped := true
+		defer func() { a.release(time.Since(start), inflight, dropped) }()
+		err := next.ServeHTTPCtx(ctx, w, r)
+		dropped = isDropped(err)
+		return err
+	})
+}
+
+// Limit returns the current limit of concurrent requests.
+func (a *Adaptive) Limit() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.limit
+}
+
+// InFlight returns the number of requests currently being served.
+func (a *Adaptive) InFlight() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.inflight
+}
+
+// Rejected returns the number of requests which were shed.
+func (a *Adaptive) Rejected() uint64 {
+	return atomic.LoadUint64(&a.shed)
+}
+
+// QueueDepth returns the number of requests waiting for capacity.
+func (a *Adaptive) QueueDepth() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.queued
+}
+
+// ClassQueueDepth returns the number of requests of the given class waiting
+// for capacity.
+func (a *Adaptive) ClassQueueDepth(class int) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if class < 0 || class >= len(a.queues) {
+		return 0
+	}
+	return a.queues[class].size
+}
+
+func (a *Adaptive) classify(r *http.Request) *waiter {
+	var c Classification
+	if a.conf.Classify != nil {
+		c = a.conf.Classify(r)
+	}
+	if c.Class < 0 || c.Class >= len(a.queues) {
+		c.Class = len(a.queues) - 1
+	}
+	if c.Cost <= 0 {
+		c.Cost = 1
+	}
+	return &waiter{
+		class:  c.Class,
+		tenant: c.Tenant,
+		cost:   c.Cost,
+		ready:  make(chan bool, 1),
+	}
+}
+
+// admissible reports whether a request of the given class can be admitted.
+// It must be called while holding the mutex.
+func (a *Adaptive) admissible(class int) bool {
+	return a.inflight < a.limit-a.reservedAbove[class]
+}
+
+// acquire admits the request, possibly after waiting in the queue. It returns
+// the number of requests in flight once it was admitted.
+func (a *Adaptive) acquire(ctx context.Context, wt *waiter) (int, bool) {
+	a.mutex.Lock()
+	if a.admissible(wt.class) {
+		a.inflight++
+		inflight := a.inflight
+		a.mutex.Unlock()
+		return inflight, true
+	}
+	if a.conf.QueueSize <= 0 || !a.enqueue(wt) {
+		a.mutex.Unlock()
+		return 0, false
+	}
+	a.mutex.Unlock()
+
+	timer := time.NewTimer(a.conf.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-wt.ready:
+		return a.admitted(ok)
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	a.mutex.Lock()
+	if a.queues[wt.class].drop(wt) {
+		a.queued--
+		a.mutex.Unlock()
+		return 0, false
+	}
+	a.mutex.Unlock()
+	// The waiter was admitted or shed in the meantime.
+	ok := <-wt.ready
+	if ok && ctx.Err() != nil {
+		a.mutex.Lock()
+		a.inflight--
+		a.dequeue()
+		a.mutex.Unlock()
+		return 0, false
+	}
+	return a.admitted(ok)
+}
+
+// enqueue adds the waiter to the queue, making room by shedding the oldest
+// waiter of the lowest priority class if necessary. It returns false if the
+// waiter itself would be the one shed. It must be called while holding the
+// mutex.
+func (a *Adaptive) enqueue(wt *waiter) bool {
+	if a.queued >= a.conf.QueueSize {
+		lowest := len(a.queues) - 1
+		for lowest > wt.class && a.queues[lowest].size == 0 {
+			lowest--
+		}
+		if a.queues[lowest].size == 0 {
+			// Every queued request has a higher priority.
+			return false
+		}
+		shed := a.queues[lowest].oldest()
+		a.queues[lowest].drop(shed)
+		a.queued--
+		shed.ready <- false
+	}
+	a.seq++
+	wt.seq = a.seq
+	a.queues[wt.class].push(wt)
+	a.queued++
+	return true
+}
+
+func (a *Adaptive) admitted(ok bool) (int, bool) {
+	if !ok {
+		return 0, false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.inflight, true
+}
+
+func (a *Adaptive) release(rtt time.Duration, inflight int, dropped bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.inflight--
+	limit := a.conf.Algorithm.Update(a.limit, rtt, inflight, dropped)
+	if limit < a.conf.MinLimit {
+		limit = a.conf.MinLimit
+	}
+	if limit > a.conf.MaxLimit {
+		limit = a.conf.MaxLimit
+	}
+	a.limit = limit
+	a.dequeue()
+}
+
+// dequeue admits waiters, highest priority class first, while there is
+// capacity. It must be called while holding the mutex.
+func (a *Adaptive) dequeue() {
+	for class, q := range a.queues {
+		for q.size > 0 && a.admissible(class) {
+			a.inflight++
+			a.queued--
+			q.pop().ready <- true
+		}
+	}
+}
+
+// isDropped reports whether the error returned by a handler is a server
+// error.
+func isDropped(err error) bool {
+	if err == nil {
+		return false
+	}
+	if httpErr, ok := err.(httpware.Err); ok {
+		return httpErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+package limitware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// blockingHandler blocks requests to '/block' until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) httpware.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		return nil
+	}
+}
+
+func TestAdaptiveShed(t *testing.T) {
+	conf := AdaptiveDefaults
+	conf.InitialLimit, conf.MaxLimit = 1, 1
+	a := NewAdaptive(conf)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	close(release)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %v, got %v", http.StatusServiceUnavailable, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("expected Retry-After header to be 1, got: %s", got)
+	}
+	if got := a.Rejected(); got != 1 {
+		t.Fatalf("expected 1 shed request, got %v", got)
+	}
+}
+
+func TestAdaptiveQueue(t *testing.T) {
+	conf := AdaptiveDefaults
+	conf.InitialLimit, conf.MaxLimit = 1, 1
+	conf.QueueSize = 1
+	conf.QueueTimeout = 5 * time.Second
+	a := NewAdaptive(conf)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+
+	queued := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		queued <- rec.Code
+	}()
+	for a.QueueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if a.InFlight() != 1 || a.Limit() != 1 {
+		t.Fatalf("expected 1 request in flight with a limit of 1, got %v and %v", a.InFlight(), a.Limit())
+	}
+
+	// A full queue sheds its oldest request.
+	newest := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		newest <- rec.Code
+	}()
+	if code := <-queued; code != http.StatusServiceUnavailable {
+		t.Fatalf("expected oldest queued request to be shed, got status code %v", code)
+	}
+
+	close(release)
+	if code := <-newest; code != http.StatusOK {
+		t.Fatalf("expected queued request to be served, got status code %v", code)
+	}
+}
+
+func TestAdaptiveQueueTimeout(t *testing.T) {
+	conf := AdaptiveDefaults
+	conf.InitialLimit, conf.MaxLimit = 1, 1
+	conf.QueueSize = 1
+	conf.QueueTimeout = 10 * time.Millisecond
+	a := NewAdaptive(conf)
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	h := httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %v, got %v", http.StatusServiceUnavailable, rec.Code)
+	}
+	if a.QueueDepth() != 0 {
+		t.Fatalf("expected empty queue, got depth %v", a.QueueDepth())
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	algorithms := map[string]Algorithm{
+		"aimd":     &AIMD{Timeout: 50 * time.Millisecond},
+		"vegas":    &Vegas{},
+		"gradient": &Gradient{},
+	}
+	for name, alg := range algorithms {
+		limit := 20
+		for i := 0; i < 50; i++ {
+			limit = alg.Update(limit, 10*time.Millisecond, limit, false)
+		}
+		if limit <= 20 {
+			t.Fatalf("%s: expected limit to grow while latency is steady, got %v", name, limit)
+		}
+		grown := limit
+		for i := 0; i < 5; i++ {
+			limit = alg.Update(limit, 100*time.Millisecond, limit, false)
+		}
+		if limit >= grown {
+			t.Fatalf("%s: expected limit to shrink as latency grows, got %v (from %v)", name, limit, grown)
+		}
+		shrunk := limit
+		if limit = alg.Update(limit, 10*time.Millisecond, limit, true); limit >= shrunk {
+			t.Fatalf("%s: expected limit to shrink when dropping, got %v (from %v)", name, limit, shrunk)
+		}
+	}
+}
+
+func TestAdaptivePriority(t *testing.T) {
+	conf := AdaptiveDefaults
+	conf.InitialLimit, conf.MaxLimit = 2, 2
+	conf.Classes = []Class{
+		{Name: "health", Reserved: 1},
+		{Name: "batch"},
+	}
+	conf.Classify = func(r *http.Request) Classification {
+		if r.URL.Path == "/healthz" {
+			return Classification{Class: 0}
+		}
+		return Classification{Class: 1}
+	}
+	a := NewAdaptive(conf)
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	h := httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(blockingHandler(started, release))
+
+	// Batch requests may only use the slot which is not reserved.
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/batch", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected batch request to be shed, got status code %v", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected health check to be served, got status code %v", rec.Code)
+	}
+}
+
+func TestAdaptiveQueueShedsLowestPriority(t *testing.T) {
+	conf := AdaptiveDefaults
+	conf.InitialLimit, conf.MaxLimit = 1, 1
+	conf.QueueSize = 1
+	conf.QueueTimeout = 5 * time.Second
+	conf.Classes = []Class{{Name: "paid"}, {Name: "batch"}}
+	conf.Classify = func(r *http.Request) Classification {
+		if r.URL.Query().Get("class") == "paid" {
+			return Classification{Class: 0}
+		}
+		return Classification{Class: 1}
+	}
+	a := NewAdaptive(conf)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+
+	serve := func(target string) <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+			code <- rec.Code
+		}()
+		return code
+	}
+	batch := serve("/?class=batch")
+	for a.ClassQueueDepth(1) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	paid := serve("/?class=paid")
+	if code := <-batch; code != http.StatusServiceUnavailable {
+		t.Fatalf("expected batch request to be shed, got status code %v", code)
+	}
+	// The queue is full of higher priority requests.
+	if code := <-serve("/?class=batch"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected batch request to be shed, got status code %v", code)
+	}
+	close(release)
+	if code := <-paid; code != http.StatusOK {
+		t.Fatalf("expected paid request to be served, got status code %v", code)
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	q := newClassQueue(1)
+	seq := uint64(0)
+	push := func(tenant string, n int) {
+		for i := 0; i < n; i++ {
+			seq++
+			q.push(&waiter{tenant: tenant, cost: 1, seq: seq})
+		}
+	}
+	// A noisy tenant queues up first.
+	push("noisy", 6)
+	push("quiet", 2)
+
+	var order []string
+	for q.size > 0 {
+		order = append(order, q.pop().tenant)
+	}
+	expected := []string{"quiet", "noisy", "quiet", "noisy", "noisy", "noisy", "noisy", "noisy"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected order %v, got %v", expected, order)
+	}
+
+	// Expensive requests use up more of a tenant's turns.
+	q = newClassQueue(2)
+	q.push(&waiter{tenant: "a", cost: 4, seq: 1})
+	q.push(&waiter{tenant: "b", cost: 1, seq: 2})
+	q.push(&waiter{tenant: "b", cost: 1, seq: 3})
+	q.push(&waiter{tenant: "b", cost: 1, seq: 4})
+	order = nil
+	for q.size > 0 {
+		order = append(order, q.pop().tenant)
+	}
+	expected = []string{"b", "b", "b", "a"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected order %v, got %v", expected, order)
+	}
+}
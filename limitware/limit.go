@@ -1,17 +1,22 @@
 /*
 Package limitware provides middleware for limiting the number of requests a
-single client can have open at one time. It implements the httpware.Middleware
-interface for easy composition with other middleware.
+single client can have open at one time, as well as the number of requests it
+can make within a window of time. The counters are kept in a Store, which can be
+shared between processes. It implements the httpware.Middleware interface for
+easy composition with other middleware.
 */
 package limitware
 
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nstogner/httpware"
 )
@@ -31,32 +36,125 @@ type Config struct {
 	RemoteLimit int
 	// The limit of total active requests
 	TotalLimit uint64
-	// Sets the header 'Retry-After'. Units are in seconds.
+	// Sets the header 'Retry-After' when RemoteLimit or TotalLimit is
+	// exceeded. Units are in seconds. Requests rejected by RemoteRate or a
+	// quota are told the time until the window or period resets instead.
 	RetryAfter int
+	// The number of requests a single remote address can make per Window.
+	// Requests are counted by their cost (see Rules). Zero disables this
+	// limit.
+	RemoteRate int
+	// The length of the RemoteRate window. Defaults to a minute.
+	Window time.Duration
+	// Store holds the counters. Defaults to a new MemoryStore, which only
+	// limits the requests handled by the current process.
+	Store Store
+	// Prepended to every key written to the Store. Defaults to "limitware:".
+	KeyPrefix string
+	// The expiry of the active request counters. Every request refreshes it,
+	// so the requests of a process which crashed while serving them are only
+	// released from a shared Store once a counter has been idle for this
+	// long. The total counter is rarely idle on a busy service, so keep
+	// TotalLimit generous when sharing a Store. Defaults to an hour.
+	CounterTTL time.Duration
+	// FailOpen lets requests through when the Store returns an error. By
+	// default they are rejected with a 503 (Service Unavailable).
+	FailOpen bool
+	// Headers sets the 'RateLimit-Policy' and 'RateLimit' headers (as
+	// defined by the IETF httpapi draft) on every response. They describe the
+	// RemoteRate limit, so they are only sent when it is set.
+	Headers bool
+	// LegacyHeaders sets the 'X-RateLimit-Limit', 'X-RateLimit-Remaining' and
+	// 'X-RateLimit-Reset' headers on every response. The reset is given in
+	// seconds since the epoch. Like Headers, it requires RemoteRate.
+	LegacyHeaders bool
+	// KeyFunc identifies the client of a request. The limits labeled as
+	// 'remote' apply to each key. Requests with an empty key are not limited.
+	// Defaults to the IP address of the remote.
+	KeyFunc func(*http.Request) string
+	// Rules assign costs to requests. The first matching rule applies,
+	// requests which match none cost 1.
+	Rules []Rule
+	// The cost a single key can spend per day (UTC). Zero disables this
+	// limit.
+	DailyQuota int64
+	// The cost a single key can spend per calendar month (UTC). Zero
+	// disables this limit.
+	MonthlyQuota int64
+	// QuotaStore holds the quota counters. Defaults to Store. Use a shared
+	// Store or a FileStore to keep quotas across restarts.
+	QuotaStore Store
 }
 
 // Middle is middleware that limits http requests.
 type Middle struct {
-	remoteLimit int
+	remoteLimit int64
 	totalLimit  uint64
+	remoteRate  int64
+	window      time.Duration
+	store       Store
+	prefix      string
+	ttl         time.Duration
+	failOpen    bool
 
-	retryHeader func(w http.ResponseWriter)
+	headers       bool
+	legacyHeaders bool
+	retryHeader   func(w http.ResponseWriter)
 
-	mutex sync.Mutex
-	total uint64
-	addrs map[string]int
+	keyFunc    func(*http.Request) string
+	rules      []Rule
+	quotas     []quota
+	quotaStore Store
+
+	// The number of rejected requests, accessed atomically.
+	rejected uint64
 }
 
 // New creates a new limitware.Middle instance. It can limit the requests per
 // remote and the total requests.
 func New(conf Config) *Middle {
 	middle := Middle{
-		remoteLimit: conf.RemoteLimit,
+		remoteLimit: int64(conf.RemoteLimit),
 		totalLimit:  conf.TotalLimit,
-		total:       0,
-		addrs:       make(map[string]int),
+		remoteRate:  int64(conf.RemoteRate),
+		window:      conf.Window,
+		store:       conf.Store,
+		prefix:      conf.KeyPrefix,
+		ttl:         conf.CounterTTL,
+		failOpen:    conf.FailOpen,
+
+		headers:       conf.Headers,
+		legacyHeaders: conf.LegacyHeaders,
+
+		keyFunc:    conf.KeyFunc,
+		rules:      append([]Rule(nil), conf.Rules...),
+		quotaStore: conf.QuotaStore,
+	}
+	if middle.window == 0 {
+		middle.window = time.Minute
+	}
+	if middle.store == nil {
+		middle.store = NewMemoryStore()
+	}
+	if middle.prefix == "" {
+		middle.prefix = "limitware:"
+	}
+	if middle.ttl == 0 {
+		middle.ttl = time.Hour
+	}
+	if middle.keyFunc == nil {
+		middle.keyFunc = remoteIP
+	}
+	if middle.quotaStore == nil {
+		middle.quotaStore = middle.store
 	}
-	if conf.RetryAfter == 0 {
+	if conf.DailyQuota > 0 {
+		middle.quotas = append(middle.quotas, quota{name: "daily", limit: conf.DailyQuota, period: daily})
+	}
+	if conf.MonthlyQuota > 0 {
+		middle.quotas = append(middle.quotas, quota{name: "monthly", limit: conf.MonthlyQuota, period: monthly})
+	}
+	if conf.RetryAfter != 0 {
 		headerValue := strconv.Itoa(conf.RetryAfter)
 		middle.retryHeader = func(w http.ResponseWriter) { w.Header().Set("Retry-After", headerValue) }
 	} else {
@@ -68,55 +166,236 @@ func New(conf Config) *Middle {
 // Handle takes the next handler as an argument and wraps it in this middleware.
 func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
 	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-		remote := strings.Split(r.RemoteAddr, ":")
-		if len(remote) != 2 {
+		key := m.keyFunc(r)
+		if key == "" {
 			return next.ServeHTTPCtx(ctx, w, r)
 		}
+		cost := m.cost(r)
 
-		if m.increment(remote[0]) {
-			defer m.decrement(remote[0])
-			return next.ServeHTTPCtx(ctx, w, r)
+		ok, err := m.limitRate(w, key, cost)
+		if err != nil {
+			return m.storeFailed(ctx, w, r, next)
+		}
+		if !ok {
+			// limitRate set the 'Retry-After' header.
+			atomic.AddUint64(&m.rejected, 1)
+			return httpware.NewErr("exceeded request rate limit", 429)
 		}
+		ok, err = m.acquire(key)
+		if err != nil {
+			return m.storeFailed(ctx, w, r, next)
+		}
+		if !ok {
+			// Send a 429 response (Too Many Requests).
+			atomic.AddUint64(&m.rejected, 1)
+			m.retryHeader(w)
+			return httpware.NewErr("exceeded request rate limit", 429)
+		}
+		defer m.release(key)
 
-		// Send a 429 response (Too Many Requests).
-		m.retryHeader(w)
-		return httpware.NewErr("exceeded request rate limit", 429)
+		exceeded, err := m.chargeQuotas(key, cost)
+		if err != nil {
+			return m.storeFailed(ctx, w, r, next)
+		}
+		if exceeded != nil {
+			atomic.AddUint64(&m.rejected, 1)
+			w.Header().Set("Retry-After", strconv.FormatInt(secondsUntil(exceeded.reset, time.Now()), 10))
+			return httpware.NewErr("exceeded request quota", 429).
+				WithField("quota", exceeded.name).
+				WithField("limit", exceeded.limit).
+				WithField("reset", exceeded.reset.Format(time.RFC3339))
+		}
+		return next.ServeHTTPCtx(ctx, w, r)
 	})
 }
 
+// Rejected returns the number of requests which were rejected for exceeding
+// a limit or quota.
+func (m *Middle) Rejected() uint64 {
+	return atomic.LoadUint64(&m.rejected)
+}
+
+// storeFailed handles a request for which the Store returned an error.
+func (m *Middle) storeFailed(ctx context.Context, w http.ResponseWriter, r *http.Request, next httpware.Handler) error {
+	if m.failOpen {
+		return next.ServeHTTPCtx(ctx, w, r)
+	}
+	return httpware.NewErr("request limits are unavailable", http.StatusServiceUnavailable)
+}
+
+// Usage describes the requests of a single remote address.
+type Usage struct {
+	Remote string `json:"remote"`
+	// The number of active requests.
+	Active int64 `json:"active"`
+	// The number of requests made in the current window.
+	Requests int64 `json:"requests"`
+	// The number of requests left in the current window. Always zero when
+	// not limiting with RemoteRate.
+	Remaining int64 `json:"remaining"`
+	// The end of the current window.
+	Reset time.Time `json:"reset"`
+}
+
+// Snapshot returns the usage of every remote address which currently has
+// active requests or has made requests in the current window, sorted by
+// address.
+func (m *Middle) Snapshot() ([]Usage, error) {
+	now := time.Now()
+	window := m.windowIndex(now)
+	reset := m.windowReset(window)
+	usage := make(map[string]*Usage)
+	get := func(remote string) *Usage {
+		u, ok := usage[remote]
+		if !ok {
+			u = &Usage{Remote: remote, Reset: reset}
+			if m.remoteRate > 0 {
+				u.Remaining = m.remoteRate
+			}
+			usage[remote] = u
+		}
+		return u
+	}
+
+	active, err := m.store.Scan(m.prefix + "active:")
+	if err != nil {
+		return nil, err
+	}
+	for k, n := range active {
+		get(strings.TrimPrefix(k, m.prefix+"active:")).Active = n
+	}
+
+	rates, err := m.store.Scan(m.prefix + "rate:")
+	if err != nil {
+		return nil, err
+	}
+	suffix := ":" + strconv.FormatInt(window, 10)
+	for k, n := range rates {
+		if !strings.HasSuffix(k, suffix) {
+			// Left over from a previous window.
+			continue
+		}
+		u := get(strings.TrimSuffix(strings.TrimPrefix(k, m.prefix+"rate:"), suffix))
+		u.Requests = n
+		if m.remoteRate > 0 {
+			u.Remaining = remaining(m.remoteRate, n)
+		}
+	}
+
+	snapshot := make([]Usage, 0, len(usage))
+	for _, u := range usage {
+		snapshot = append(snapshot, *u)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Remote < snapshot[j].Remote })
+	return snapshot, nil
+}
+
 // TotalRate gets the total number of active requests.
 func (m *Middle) TotalRate() uint64 {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	fmt.Println("totalRate", m.total)
-	return m.total
+	n, _ := m.store.Get(m.totalKey())
+	return uint64(n)
 }
 
 // RemoteRate gets the number of active requests for a given remote.
 func (m *Middle) RemoteRate(addr string) int {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	return m.addrs[addr]
+	n, _ := m.store.Get(m.activeKey(addr))
+	return int(n)
+}
+
+func (m *Middle) totalKey() string { return m.prefix + "total" }
+
+func (m *Middle) activeKey(addr string) string { return m.prefix + "active:" + addr }
+
+func (m *Middle) rateKey(addr string, window int64) string {
+	return m.prefix + "rate:" + addr + ":" + strconv.FormatInt(window, 10)
+}
+
+func (m *Middle) windowIndex(now time.Time) int64 { return now.UnixNano() / int64(m.window) }
+
+func (m *Middle) windowReset(window int64) time.Time {
+	return time.Unix(0, (window+1)*int64(m.window))
+}
+
+// limitRate counts a new request from the given remote against RemoteRate
+// and sets the rate limit headers. It returns false if the limit was
+// exceeded, after setting the 'Retry-After' header to the end of the window.
+func (m *Middle) limitRate(w http.ResponseWriter, addr string, cost int64) (bool, error) {
+	if m.remoteRate <= 0 {
+		return true, nil
+	}
+	now := time.Now()
+	window := m.windowIndex(now)
+	n, err := m.store.Incr(m.rateKey(addr, window), cost, m.window)
+	if err != nil {
+		return false, err
+	}
+
+	left := remaining(m.remoteRate, n)
+	reset := m.windowReset(window)
+	resetSecs := secondsUntil(reset, now)
+	if m.headers {
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf(`"remote";q=%d;w=%d`, m.remoteRate, int64(m.window/time.Second)))
+		w.Header().Set("RateLimit", fmt.Sprintf(`"remote";r=%d;t=%d`, left, resetSecs))
+	}
+	if m.legacyHeaders {
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(m.remoteRate, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(left, 10))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+	if n > m.remoteRate {
+		w.Header().Set("Retry-After", strconv.FormatInt(resetSecs, 10))
+		return false, nil
+	}
+	return true, nil
+}
+
+// acquire counts a new active request from the given remote. It returns false
+// if any of the limits were exceeded, in which case nothing needs to be
+// released.
+func (m *Middle) acquire(addr string) (bool, error) {
+	n, err := m.store.Incr(m.activeKey(addr), 1, m.ttl)
+	if err != nil {
+		return false, err
+	}
+	if n > m.remoteLimit {
+		m.store.Incr(m.activeKey(addr), -1, m.ttl)
+		return false, nil
+	}
+
+	n, err = m.store.Incr(m.totalKey(), 1, m.ttl)
+	if err != nil {
+		m.store.Incr(m.activeKey(addr), -1, m.ttl)
+		return false, err
+	}
+	if n < 0 || uint64(n) > m.totalLimit {
+		m.release(addr)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *Middle) release(addr string) {
+	m.store.Incr(m.activeKey(addr), -1, m.ttl)
+	m.store.Incr(m.totalKey(), -1, m.ttl)
 }
 
-func (m *Middle) increment(addr string) bool {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	if m.addrs[addr] < m.remoteLimit && m.total < m.totalLimit {
-		m.addrs[addr]++
-		m.total++
-		return true
+// remoteIP returns the IP address of the remote which made the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
 	}
-	return false
+	return host
 }
 
-func (m *Middle) decrement(addr string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	if m.addrs[addr] <= 1 {
-		delete(m.addrs, addr)
-	} else {
-		m.addrs[addr]--
+// secondsUntil rounds up, so that clients never retry too early.
+func secondsUntil(t, now time.Time) int64 {
+	return int64((t.Sub(now) + time.Second - 1) / time.Second)
+}
+
+func remaining(limit, used int64) int64 {
+	if used >= limit {
+		return 0
 	}
-	m.total--
+	return limit - used
 }
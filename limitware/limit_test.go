@@ -2,8 +2,11 @@ package limitware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -137,3 +140,191 @@ func TestCompositeLimit(t *testing.T) {
 	}
 
 }
+
+func TestRemoteRate(t *testing.T) {
+	conf := Config{
+		RemoteLimit: 10,
+		TotalLimit:  10,
+		RemoteRate:  2,
+		Window:      time.Hour,
+	}
+	limiter := New(conf)
+	m := httpware.Compose(
+		httpware.DefaultErrHandler,
+		limiter,
+	)
+	s := httptest.NewServer(m.ThenFunc(testHandler))
+	defer s.Close()
+
+	for i, expected := range []int{200, 200, 429} {
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("request %v: expected status code %v, got %v", i, expected, resp.StatusCode)
+		}
+	}
+	if got := limiter.Rejected(); got != 1 {
+		t.Fatalf("expected 1 rejected request, got %v", got)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Incr(string, int64, time.Duration) (int64, error) {
+	return 0, errors.New("store is down")
+}
+func (failingStore) Get(string) (int64, error) { return 0, errors.New("store is down") }
+func (failingStore) Scan(string) (map[string]int64, error) {
+	return nil, errors.New("store is down")
+}
+
+func TestStoreFailure(t *testing.T) {
+	cases := []struct {
+		FailOpen bool
+		Expected int
+	}{
+		{FailOpen: false, Expected: http.StatusServiceUnavailable},
+		{FailOpen: true, Expected: http.StatusOK},
+	}
+	for _, c := range cases {
+		conf := Defaults
+		conf.Store = failingStore{}
+		conf.FailOpen = c.FailOpen
+		m := httpware.Compose(
+			httpware.DefaultErrHandler,
+			New(conf),
+		)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		m.ThenFunc(testHandler).ServeHTTP(rec, req)
+		if rec.Code != c.Expected {
+			t.Fatalf("fail open %v: expected status code %v, got %v", c.FailOpen, c.Expected, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	conf := Config{
+		RemoteLimit:   10,
+		TotalLimit:    10,
+		RemoteRate:    2,
+		Window:        time.Hour,
+		RetryAfter:    60,
+		Headers:       true,
+		LegacyHeaders: true,
+	}
+	h := httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(testHandler)
+
+	cases := []struct {
+		Status    int
+		Remaining string
+	}{
+		{Status: 200, Remaining: "1"},
+		{Status: 200, Remaining: "0"},
+		{Status: 429, Remaining: "0"},
+	}
+	for i, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != c.Status {
+			t.Fatalf("request %v: expected status code %v, got %v", i, c.Status, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Policy"); got != `"remote";q=2;w=3600` {
+			t.Fatalf("request %v: unexpected RateLimit-Policy header: %s", i, got)
+		}
+		if got := rec.Header().Get("RateLimit"); !strings.HasPrefix(got, `"remote";r=`+c.Remaining+";t=") {
+			t.Fatalf("request %v: unexpected RateLimit header: %s", i, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Fatalf("request %v: unexpected X-RateLimit-Limit header: %s", i, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != c.Remaining {
+			t.Fatalf("request %v: unexpected X-RateLimit-Remaining header: %s", i, got)
+		}
+		if rec.Header().Get("X-RateLimit-Reset") == "" {
+			t.Fatalf("request %v: expected X-RateLimit-Reset header", i)
+		}
+	}
+	// Rejections by RemoteRate are told when the window resets, not the
+	// fixed RetryAfter.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	reset := strings.TrimPrefix(rec.Header().Get("RateLimit"), `"remote";r=0;t=`)
+	if got := rec.Header().Get("Retry-After"); got == "60" || got != reset {
+		t.Fatalf("expected Retry-After header to match the RateLimit reset %s, got: %s", reset, got)
+	}
+
+	// Rejections by RemoteLimit use the fixed RetryAfter.
+	conf.RemoteRate = 0
+	conf.RemoteLimit = 1
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	h = httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		close(started)
+		<-release
+		return nil
+	})
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 429 {
+		t.Fatalf("expected status code 429, got %v", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("expected Retry-After header to be 60, got: %s", got)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	conf := Config{
+		RemoteLimit: 10,
+		TotalLimit:  10,
+		RemoteRate:  5,
+		Window:      time.Hour,
+	}
+	mid := New(conf)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := httpware.Compose(httpware.DefaultErrHandler, mid).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		return nil
+	})
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-started
+	other := httptest.NewRequest("GET", "/", nil)
+	other.RemoteAddr = "10.0.0.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), other)
+
+	snapshot, err := mid.Snapshot()
+	close(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("expected usage of 2 remotes, got: %+v", snapshot)
+	}
+	if u := snapshot[0]; u.Remote != "10.0.0.1" || u.Active != 0 || u.Requests != 1 || u.Remaining != 4 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	if u := snapshot[1]; u.Remote != "192.0.2.1" || u.Active != 1 || u.Requests != 2 || u.Remaining != 3 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+
+	// A used up quota is not mistaken for no limit.
+	bs, err := json.Marshal(Usage{Remote: "192.0.2.1", Requests: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), `"remaining":0`) {
+		t.Fatalf("expected remaining to be included, got: %s", bs)
+	}
+}
@@ -0,0 +1,124 @@
+package limitware
+
+// waiter is a request waiting for capacity in an Adaptive queue.
+type waiter struct {
+	class  int
+	tenant string
+	cost   int
+	// Order of arrival, used to find the oldest waiter.
+	seq uint64
+	// Receives true when the request is admitted and false when it is shed.
+	ready chan bool
+}
+
+// classQueue holds the waiters of a single priority class. Tenants take turns
+// using deficit round robin: each turn a tenant is credited with the quantum
+// and may admit waiters as long as its credit covers their cost. The waiters
+// of a single tenant are admitted in LIFO order.
+type classQueue struct {
+	quantum int
+	tenants map[string]*tenantQueue
+	// Tenants with waiters, in round robin order.
+	active []*tenantQueue
+	next   int
+	size   int
+}
+
+type tenantQueue struct {
+	name    string
+	waiters []*waiter
+	deficit int
+}
+
+func newClassQueue(quantum int) *classQueue {
+	return &classQueue{
+		quantum: quantum,
+		tenants: make(map[string]*tenantQueue),
+	}
+}
+
+func (q *classQueue) push(w *waiter) {
+	tq, ok := q.tenants[w.tenant]
+	if !ok {
+		tq = &tenantQueue{name: w.tenant}
+		q.tenants[w.tenant] = tq
+	}
+	if len(tq.waiters) == 0 {
+		q.active = append(q.active, tq)
+	}
+	tq.waiters = append(tq.waiters, w)
+	q.size++
+}
+
+// pop removes the next waiter to be admitted. The queue must not be empty.
+func (q *classQueue) pop() *waiter {
+	for {
+		tq := q.active[q.next]
+		last := len(tq.waiters) - 1
+		w := tq.waiters[last]
+		if tq.deficit >= w.cost {
+			tq.deficit -= w.cost
+			q.remove(tq, last)
+			return w
+		}
+		// Move on to the next tenant and credit it with its quantum.
+		q.next = (q.next + 1) % len(q.active)
+		q.active[q.next].deficit += q.quantum
+	}
+}
+
+// oldest returns the waiter which has been waiting the longest, or nil.
+func (q *classQueue) oldest() *waiter {
+	var oldest *waiter
+	for _, tq := range q.active {
+		if w := tq.waiters[0]; oldest == nil || w.seq < oldest.seq {
+			oldest = w
+		}
+	}
+	return oldest
+}
+
+// drop removes the given waiter, if it is still queued, and reports whether
+// it was.
+func (q *classQueue) drop(w *waiter) bool {
+	tq, ok := q.tenants[w.tenant]
+	if !ok {
+		return false
+	}
+	for i, qw := range tq.waiters {
+		if qw == w {
+			q.remove(tq, i)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *classQueue) remove(tq *tenantQueue, i int) {
+	tq.waiters = append(tq.waiters[:i], tq.waiters[i+1:]...)
+	q.size--
+	if len(tq.waiters) > 0 {
+		return
+	}
+	// Idle tenants lose their credit and their place in the rotation.
+	tq.deficit = 0
+	delete(q.tenants, tq.name)
+	for i, a := range q.active {
+		if a != tq {
+			continue
+		}
+		current := i == q.next
+		q.active = append(q.active[:i], q.active[i+1:]...)
+		if i < q.next {
+			q.next--
+		}
+		if q.next >= len(q.active) {
+			q.next = 0
+		}
+		if current && len(q.active) > 0 {
+			// The next tenant's turn starts now.
+			q.active[q.next].deficit += q.quantum
+		}
+		return
+	}
+}
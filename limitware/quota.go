@@ -0,0 +1,101 @@
+package limitware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rule assigns a cost to the requests it matches. Costs are counted against
+// RemoteRate and the quotas instead of counting each request as one.
+type Rule struct {
+	// The request method to match, ie: "POST". Empty matches any method.
+	Method string
+	// The prefix of the request path to match, ie: "/reports/". Empty matches
+	// any path.
+	PathPrefix string
+	// Defaults to 1.
+	Cost int
+}
+
+func (rl Rule) matches(r *http.Request) bool {
+	if rl.Method != "" && rl.Method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, rl.PathPrefix)
+}
+
+// cost returns the cost of the first matching rule, or 1 if none match.
+func (m *Middle) cost(r *http.Request) int64 {
+	for _, rl := range m.rules {
+		if rl.matches(r) {
+			if rl.Cost <= 0 {
+				return 1
+			}
+			return int64(rl.Cost)
+		}
+	}
+	return 1
+}
+
+// quota is a budget of cost units per period of time.
+type quota struct {
+	name  string
+	limit int64
+	// period identifies the period which contains now and when it ends.
+	period func(now time.Time) (string, time.Time)
+}
+
+func daily(now time.Time) (string, time.Time) {
+	now = now.UTC()
+	y, m, d := now.Date()
+	return now.Format("20060102"), time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+func monthly(now time.Time) (string, time.Time) {
+	now = now.UTC()
+	y, m, _ := now.Date()
+	return now.Format("200601"), time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// exceededQuota describes the quota which rejected a request.
+type exceededQuota struct {
+	name  string
+	limit int64
+	reset time.Time
+}
+
+// chargeQuotas counts the cost of a request against each of the quotas. If
+// any of them is exceeded, the charges are refunded and the exceeded quota is
+// returned.
+func (m *Middle) chargeQuotas(key string, cost int64) (*exceededQuota, error) {
+	now := time.Now()
+	type charge struct {
+		key string
+		ttl time.Duration
+	}
+	var charged []charge
+	refund := func() {
+		for _, c := range charged {
+			m.quotaStore.Incr(c.key, -cost, c.ttl)
+		}
+	}
+	for _, q := range m.quotas {
+		id, reset := q.period(now)
+		k := m.prefix + "quota:" + q.name + ":" + key + ":" + id
+		// Keep the counter around a little longer than the period, the key
+		// changes with the next period anyway.
+		ttl := reset.Sub(now) + time.Hour
+		n, err := m.quotaStore.Incr(k, cost, ttl)
+		if err != nil {
+			refund()
+			return nil, err
+		}
+		charged = append(charged, charge{key: k, ttl: ttl})
+		if n > q.limit {
+			refund()
+			return &exceededQuota{name: q.name, limit: q.limit, reset: reset}, nil
+		}
+	}
+	return nil, nil
+}
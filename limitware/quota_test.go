@@ -0,0 +1,139 @@
+package limitware
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestQuota(t *testing.T) {
+	conf := Defaults
+	conf.KeyFunc = func(r *http.Request) string { return r.Header.Get("X-Client") }
+	conf.Rules = []Rule{
+		{Method: "POST", PathPrefix: "/reports/", Cost: 50},
+	}
+	conf.DailyQuota = 60
+	h := httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(testHandler)
+
+	serve := func(method, path, client string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Client", client)
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("POST", "/reports/export", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %v, got %v", http.StatusOK, rec.Code)
+	}
+	for i := 0; i < 10; i++ {
+		if rec := serve("GET", "/reports/export", "a"); rec.Code != http.StatusOK {
+			t.Fatalf("request %v: expected status code %v, got %v", i, http.StatusOK, rec.Code)
+		}
+	}
+	rec := serve("GET", "/", "a")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %v, got %v", http.StatusTooManyRequests, rec.Code)
+	}
+	var body struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	reset, err := time.Parse(time.RFC3339, body.Fields["reset"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, expected := daily(time.Now()); !reset.Equal(expected) {
+		t.Fatalf("expected reset at %v, got %v", expected, reset)
+	}
+	if body.Fields["quota"] != "daily" {
+		t.Fatalf("expected the daily quota to be exceeded, got: %v", body.Fields)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+
+	// Rejected requests are not charged and clients are counted separately.
+	if rec := serve("POST", "/reports/export", "b"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %v, got %v", http.StatusOK, rec.Code)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	now := time.Date(2016, 12, 31, 23, 0, 0, 0, time.UTC)
+	if id, reset := daily(now); id != "20161231" || !reset.Equal(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected daily period: %v, %v", id, reset)
+	}
+	if id, reset := monthly(now); id != "201612" || !reset.Equal(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected monthly period: %v, %v", id, reset)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limitware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quotas.json")
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Incr("a", 5, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Incr("expired", 5, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero interval falls back to the default.
+	s, err = NewFileStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n, err := s.Incr("a", 1, time.Hour); err != nil || n != 6 {
+		t.Fatalf("expected restored counter to be incremented to 6, got %v (err: %v)", n, err)
+	}
+	if n, _ := s.Get("expired"); n != 0 {
+		t.Fatalf("expected expired counter to be 0, got %v", n)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	// A counter per window, as RemoteRate creates them.
+	for i := 0; i < 200; i++ {
+		if _, err := s.Incr("rate:a:"+strconv.Itoa(i), 1, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Millisecond)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err := s.Incr("rate:a:200", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	s.mutex.Lock()
+	n := len(s.counters)
+	s.mutex.Unlock()
+	if n != 1 {
+		t.Fatalf("expected the expired counters to be removed, %v are left", n)
+	}
+}
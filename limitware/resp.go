@@ -0,0 +1,341 @@
+package limitware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RESPConfig is used to initialize a new RESPStore.
+type RESPConfig struct {
+	// Address of the server, ie: "localhost:6379".
+	Addr string
+	// Sent with the AUTH command when a new connection is made (if not empty).
+	Password string
+	// Selected with the SELECT command when a new connection is made.
+	DB int
+	// Timeout for dialing and for each round trip to the server.
+	Timeout time.Duration
+	// The number of idle connections kept for reuse.
+	MaxIdle int
+}
+
+// RESPStore is a Store which keeps its counters on a server that speaks the
+// Redis serialization protocol (RESP), such as Redis itself. Increments are
+// made atomic through MULTI/EXEC transactions, no scripting is required.
+type RESPStore struct {
+	conf RESPConfig
+	idle chan *respConn
+}
+
+// NewRESPStore returns a new RESPStore. Connections are made lazily.
+func NewRESPStore(conf RESPConfig) *RESPStore {
+	if conf.Timeout == 0 {
+		conf.Timeout = time.Second
+	}
+	if conf.MaxIdle == 0 {
+		conf.MaxIdle = 8
+	}
+	return &RESPStore{
+		conf: conf,
+		idle: make(chan *respConn, conf.MaxIdle),
+	}
+}
+
+// Incr fulfills the Store interface. Like in a MemoryStore, counters which
+// drop to zero or below are removed.
+func (s *RESPStore) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	rs, err := s.do(
+		[]string{"MULTI"},
+		[]string{"INCRBY", key, strconv.FormatInt(delta, 10)},
+		[]string{"PEXPIRE", key, strconv.FormatInt(int64(ttl/time.Millisecond), 10)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, err
+	}
+	exec, ok := rs[3].([]interface{})
+	if !ok || len(exec) != 2 {
+		return 0, fmt.Errorf("limitware: unexpected EXEC reply: %v", rs[3])
+	}
+	n, ok := exec[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("limitware: unexpected INCRBY reply: %v", exec[0])
+	}
+	if n <= 0 {
+		// The counter was changed all the same, so a failure only leaves it
+		// to expire after ttl.
+		s.remove(key)
+	}
+	return n, nil
+}
+
+// remove deletes the counter stored at key unless it was raised above zero in
+// the meantime. WATCH aborts the transaction if the counter changes between
+// the GET and the EXEC.
+func (s *RESPStore) remove(key string) error {
+	c, err := s.get()
+	if err != nil {
+		return err
+	}
+	rs, err := c.roundTrip(s.conf.Timeout, []string{"WATCH", key}, []string{"GET", key})
+	var n int64
+	if err == nil {
+		n, err = parseRESPInt(rs[1])
+	}
+	if err == nil {
+		if n <= 0 {
+			_, err = c.roundTrip(s.conf.Timeout, []string{"MULTI"}, []string{"DEL", key}, []string{"EXEC"})
+		} else {
+			_, err = c.roundTrip(s.conf.Timeout, []string{"UNWATCH"})
+		}
+	}
+	if err != nil {
+		// The connection may still be watching the key.
+		c.conn.Close()
+		return err
+	}
+	s.put(c)
+	return nil
+}
+
+// Get fulfills the Store interface.
+func (s *RESPStore) Get(key string) (int64, error) {
+	rs, err := s.do([]string{"GET", key})
+	if err != nil {
+		return 0, err
+	}
+	return parseRESPInt(rs[0])
+}
+
+// Scan fulfills the Store interface.
+func (s *RESPStore) Scan(prefix string) (map[string]int64, error) {
+	found := make(map[string]int64)
+	cursor := "0"
+	for {
+		rs, err := s.do([]string{"SCAN", cursor, "MATCH", escapeGlob(prefix) + "*", "COUNT", "100"})
+		if err != nil {
+			return nil, err
+		}
+		page, ok := rs[0].([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("limitware: unexpected SCAN reply: %v", rs[0])
+		}
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			mget := []string{"MGET"}
+			for _, k := range keys {
+				mget = append(mget, fmt.Sprint(k))
+			}
+			vs, err := s.do(mget)
+			if err != nil {
+				return nil, err
+			}
+			values, _ := vs[0].([]interface{})
+			for i, v := range values {
+				if v == nil {
+					// Expired in between the calls.
+					continue
+				}
+				n, err := parseRESPInt(v)
+				if err != nil {
+					return nil, err
+				}
+				found[mget[i+1]] = n
+			}
+		}
+		cursor = fmt.Sprint(page[0])
+		if cursor == "0" {
+			return found, nil
+		}
+	}
+}
+
+// do sends the commands in a single pipeline and returns one reply per
+// command.
+func (s *RESPStore) do(cmds ...[]string) ([]interface{}, error) {
+	c, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := c.roundTrip(s.conf.Timeout, cmds...)
+	if err != nil {
+		var rerr respError
+		if !errors.As(err, &rerr) {
+			// The connection is in an unknown state.
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	s.put(c)
+	return rs, err
+}
+
+func (s *RESPStore) get() (*respConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", s.conf.Addr, s.conf.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &respConn{conn: conn, r: bufio.NewReader(conn)}
+	var setup [][]string
+	if s.conf.Password != "" {
+		setup = append(setup, []string{"AUTH", s.conf.Password})
+	}
+	if s.conf.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.conf.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.roundTrip(s.conf.Timeout, setup...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (s *RESPStore) put(c *respConn) {
+	select {
+	case s.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// Close closes all idle connections.
+func (s *RESPStore) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// respError is an error reply sent by the server.
+type respError string
+
+func (e respError) Error() string { return "limitware: " + string(e) }
+
+func (c *respConn) roundTrip(timeout time.Duration, cmds ...[]string) ([]interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	var buf []byte
+	for _, cmd := range cmds {
+		buf = appendRESPCommand(buf, cmd)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	rs := make([]interface{}, len(cmds))
+	var firstErr error
+	for i := range cmds {
+		v, err := readRESP(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := v.(respError); ok && firstErr == nil {
+			firstErr = e
+		}
+		rs[i] = v
+	}
+	return rs, firstErr
+}
+
+func appendRESPCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readRESP reads a single reply. Simple and bulk strings are returned as
+// strings, integers as int64, arrays as []interface{} and null values as nil.
+// Error replies are returned as a respError value (not as an error).
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("limitware: malformed RESP line %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			if vs[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+	return nil, fmt.Errorf("limitware: unknown RESP type %q", line[0])
+}
+
+func parseRESPInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("limitware: unexpected reply: %v", v)
+}
+
+// escapeGlob escapes the characters which have a special meaning in SCAN
+// MATCH patterns.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
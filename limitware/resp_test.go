@@ -0,0 +1,232 @@
+package limitware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// fakeRESPServer implements just enough of the RESP protocol and the Redis
+// commands to back a RESPStore.
+type fakeRESPServer struct {
+	ln      net.Listener
+	mutex   sync.Mutex
+	values  map[string]int64
+	expires map[string]time.Time
+}
+
+func newFakeRESPServer(t *testing.T) *fakeRESPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRESPServer{
+		ln:      ln,
+		values:  make(map[string]int64),
+		expires: make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRESPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	multi := false
+	for {
+		v, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		var cmd []string
+		for _, a := range v.([]interface{}) {
+			cmd = append(cmd, a.(string))
+		}
+		var reply []byte
+		switch name := strings.ToUpper(cmd[0]); {
+		case name == "MULTI":
+			multi = true
+			reply = []byte("+OK\r\n")
+		case name == "EXEC":
+			reply = []byte("*" + strconv.Itoa(len(queued)) + "\r\n")
+			for _, q := range queued {
+				reply = append(reply, s.exec(q)...)
+			}
+			queued, multi = nil, false
+		case multi:
+			queued = append(queued, cmd)
+			reply = []byte("+QUEUED\r\n")
+		default:
+			reply = s.exec(cmd)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRESPServer) exec(cmd []string) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k, exp := range s.expires {
+		if time.Now().After(exp) {
+			delete(s.values, k)
+			delete(s.expires, k)
+		}
+	}
+	switch strings.ToUpper(cmd[0]) {
+	case "INCRBY":
+		n, _ := strconv.ParseInt(cmd[2], 10, 64)
+		s.values[cmd[1]] += n
+		return []byte(":" + strconv.FormatInt(s.values[cmd[1]], 10) + "\r\n")
+	case "PEXPIRE":
+		ms, _ := strconv.ParseInt(cmd[2], 10, 64)
+		s.expires[cmd[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return []byte(":1\r\n")
+	case "GET":
+		return s.bulk(cmd[1])
+	case "DEL":
+		delete(s.values, cmd[1])
+		delete(s.expires, cmd[1])
+		return []byte(":1\r\n")
+	case "WATCH", "UNWATCH":
+		return []byte("+OK\r\n")
+	case "MGET":
+		reply := []byte("*" + strconv.Itoa(len(cmd)-1) + "\r\n")
+		for _, k := range cmd[1:] {
+			reply = append(reply, s.bulk(k)...)
+		}
+		return reply
+	case "SCAN":
+		prefix := strings.NewReplacer(`\`, "").Replace(strings.TrimSuffix(cmd[3], "*"))
+		var keys []string
+		for k := range s.values {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		return appendRESPCommand([]byte("*2\r\n$1\r\n0\r\n"), keys)
+	}
+	return []byte("-ERR unknown command '" + cmd[0] + "'\r\n")
+}
+
+func (s *fakeRESPServer) bulk(key string) []byte {
+	v, ok := s.values[key]
+	if !ok {
+		return []byte("$-1\r\n")
+	}
+	n := strconv.FormatInt(v, 10)
+	return []byte("$" + strconv.Itoa(len(n)) + "\r\n" + n + "\r\n")
+}
+
+func TestRESPStore(t *testing.T) {
+	srv := newFakeRESPServer(t)
+	defer srv.ln.Close()
+	s := NewRESPStore(RESPConfig{Addr: srv.ln.Addr().String()})
+	defer s.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := s.Incr("a:1", 1, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("expected counter to be %v, got %v", i, n)
+		}
+	}
+	if _, err := s.Incr("b:1", 5, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Get("a:1"); err != nil || n != 3 {
+		t.Fatalf("expected counter to be 3, got %v (err: %v)", n, err)
+	}
+	if n, err := s.Get("missing"); err != nil || n != 0 {
+		t.Fatalf("expected missing counter to be 0, got %v (err: %v)", n, err)
+	}
+	found, err := s.Scan("a:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found["a:1"] != 3 {
+		t.Fatalf("unexpected scan result: %v", found)
+	}
+
+	// Counters which drop to zero are removed, as in a MemoryStore.
+	if n, err := s.Incr("b:1", -5, time.Minute); err != nil || n != 0 {
+		t.Fatalf("expected counter to be 0, got %v (err: %v)", n, err)
+	}
+	if n, err := s.Incr("d", -1, time.Minute); err != nil || n != -1 {
+		t.Fatalf("expected counter to be -1, got %v (err: %v)", n, err)
+	}
+	srv.mutex.Lock()
+	_, okB := srv.values["b:1"]
+	_, okD := srv.values["d"]
+	srv.mutex.Unlock()
+	if okB || okD {
+		t.Fatal("expected counters at or below zero to be removed")
+	}
+	if n, err := s.Incr("d", 1, time.Minute); err != nil || n != 1 {
+		t.Fatalf("expected removed counter to start at zero, got %v (err: %v)", n, err)
+	}
+
+	// Expiry.
+	if _, err := s.Incr("c", 1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n, err := s.Get("c"); err != nil || n != 0 {
+		t.Fatalf("expected expired counter to be 0, got %v (err: %v)", n, err)
+	}
+
+	// Error replies are returned without spoiling the connection.
+	if _, err := s.do([]string{"BOGUS"}); err == nil {
+		t.Fatal("expected an error reply")
+	}
+	if n, err := s.Get("a:1"); err != nil || n != 3 {
+		t.Fatalf("expected counter to be 3, got %v (err: %v)", n, err)
+	}
+}
+
+// TestSharedStore ensures that two instances of the middleware (ie: two
+// replicas) enforce their limits together when they share a Store.
+func TestSharedStore(t *testing.T) {
+	srv := newFakeRESPServer(t)
+	defer srv.ln.Close()
+
+	conf := Config{
+		RemoteLimit: 10,
+		TotalLimit:  10,
+		RemoteRate:  3,
+		Window:      time.Hour,
+		Store:       NewRESPStore(RESPConfig{Addr: srv.ln.Addr().String()}),
+	}
+	replicas := []httpware.Handler{
+		httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(testHandler),
+		httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(testHandler),
+	}
+	for i, expected := range []int{200, 200, 200, 429} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		replicas[i%2].ServeHTTPCtx(context.Background(), rec, req)
+		if rec.Code != expected {
+			t.Fatalf("request %v: expected status code %v, got %v", i, expected, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package limitware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the counters used by the middleware. Sharing a Store between
+// several processes (ie: replicas behind a load balancer) makes the limits
+// apply to all of them together instead of to each process individually.
+type Store interface {
+	// Incr atomically adds delta to the counter stored at key and returns the
+	// new value. Missing keys start at zero. The key expires after ttl; every
+	// call resets the expiry.
+	Incr(key string, delta int64, ttl time.Duration) (int64, error)
+	// Get returns the value of the counter stored at key, or zero if it does
+	// not exist.
+	Get(key string) (int64, error)
+	// Scan returns all of the counters whose keys begin with prefix.
+	Scan(prefix string) (map[string]int64, error)
+}
+
+// MemoryStore is a Store which keeps its counters in the memory of the
+// current process. Expired counters are removed at most once a second while
+// incrementing, and while scanning.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]memoryCounter),
+		now:      time.Now,
+	}
+}
+
+// Incr fulfills the Store interface. Counters which drop to zero or below are
+// removed.
+func (s *MemoryStore) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Second {
+		// Forget the counters of past windows and periods.
+		s.lastSweep = now
+		for k, c := range s.counters {
+			if c.expired(now) {
+				delete(s.counters, k)
+			}
+		}
+	}
+	c, ok := s.counters[key]
+	if !ok || c.expired(now) {
+		c = memoryCounter{}
+	}
+	c.value += delta
+	if c.value <= 0 {
+		delete(s.counters, key)
+		return c.value, nil
+	}
+	c.expires = now.Add(ttl)
+	s.counters[key] = c
+	return c.value, nil
+}
+
+// Get fulfills the Store interface.
+func (s *MemoryStore) Get(key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.counters[key]
+	if !ok || c.expired(s.now()) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+// Scan fulfills the Store interface. Expired counters are removed while
+// scanning.
+func (s *MemoryStore) Scan(prefix string) (map[string]int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	found := make(map[string]int64)
+	for k, c := range s.counters {
+		if c.expired(now) {
+			delete(s.counters, k)
+			continue
+		}
+		if strings.HasPrefix(k, prefix) {
+			found[k] = c.value
+		}
+	}
+	return found, nil
+}
+
+func (c memoryCounter) expired(now time.Time) bool {
+	return !c.expires.IsZero() && !now.Before(c.expires)
+}
+
+// FileStore is a MemoryStore which is saved to a file, so that its counters
+// survive restarts. Changes are written periodically and on Close.
+type FileStore struct {
+	*MemoryStore
+	path  string
+	dirty int32
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewFileStore loads the counters saved at path (if the file exists) and
+// saves them every interval while they change. An interval of zero or less
+// defaults to 10 seconds.
+func NewFileStore(path string, interval time.Duration) (*FileStore, error) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(bs) > 0 {
+		var saved map[string]fileCounter
+		if err := json.Unmarshal(bs, &saved); err != nil {
+			return nil, fmt.Errorf("limitware: reading %s: %v", path, err)
+		}
+		for k, c := range saved {
+			s.counters[k] = memoryCounter{value: c.Value, expires: c.Expires}
+		}
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+type fileCounter struct {
+	Value   int64     `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Incr fulfills the Store interface.
+func (s *FileStore) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	atomic.StoreInt32(&s.dirty, 1)
+	return s.MemoryStore.Incr(key, delta, ttl)
+}
+
+func (s *FileStore) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.CompareAndSwapInt32(&s.dirty, 1, 0) {
+				if err := s.Flush(); err != nil {
+					// Try again on the next tick.
+					atomic.StoreInt32(&s.dirty, 1)
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Flush saves the counters. The file is replaced atomically, so a crash while
+// saving leaves the previous version in place.
+func (s *FileStore) Flush() error {
+	s.mutex.Lock()
+	now := s.now()
+	saved := make(map[string]fileCounter, len(s.counters))
+	for k, c := range s.counters {
+		if !c.expired(now) {
+			saved[k] = fileCounter{Value: c.value, Expires: c.expires}
+		}
+	}
+	s.mutex.Unlock()
+
+	bs, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Close stops the periodic saving and saves the counters one last time.
+func (s *FileStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
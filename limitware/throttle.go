@@ -0,0 +1,264 @@
+package limitware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// ThrottleConfig is used to initialize a new instance of Throttle.
+type ThrottleConfig struct {
+	// The number of response bytes per second a single key can receive.
+	// Zero disables this limit.
+	KeyRate int64
+	// The number of response bytes per second across all responses. Zero
+	// disables this limit.
+	TotalRate int64
+	// The number of bytes which can be sent at once after a pause. Defaults
+	// to a second's worth of the rate.
+	Burst int64
+	// KeyFunc identifies the client of a request. Requests with an empty key
+	// are only subject to TotalRate. Defaults to the IP address of the
+	// remote.
+	KeyFunc func(*http.Request) string
+}
+
+// Throttle is middleware which limits the bandwidth used by responses. It
+// paces writes to the http.ResponseWriter using token buckets. When neither
+// limit applies to a request the http.ResponseWriter is passed on untouched,
+// so optimizations such as sendfile (used by http.ServeContent through the
+// io.ReaderFrom interface) keep working.
+type Throttle struct {
+	conf  ThrottleConfig
+	total *bucket
+
+	mutex     sync.Mutex
+	keys      map[string]*keyBucket
+	lastSweep time.Time
+}
+
+type keyBucket struct {
+	*bucket
+	// The number of responses using the bucket.
+	refs int
+}
+
+// NewThrottle returns a new instance of Throttle.
+func NewThrottle(conf ThrottleConfig) *Throttle {
+	if conf.KeyFunc == nil {
+		conf.KeyFunc = remoteIP
+	}
+	t := &Throttle{
+		conf: conf,
+		keys: make(map[string]*keyBucket),
+	}
+	if conf.TotalRate > 0 {
+		t.total = newBucket(conf.TotalRate, conf.Burst)
+	}
+	return t
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (t *Throttle) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var buckets []*bucket
+		if t.total != nil {
+			buckets = append(buckets, t.total)
+		}
+		if key := t.conf.KeyFunc(r); key != "" && t.conf.KeyRate > 0 {
+			buckets = append(buckets, t.acquire(key))
+			defer t.release(key)
+		}
+		if len(buckets) == 0 {
+			return next.ServeHTTPCtx(ctx, w, r)
+		}
+		tw := &throttledWriter{ResponseWriter: w, ctx: ctx, reqCtx: r.Context(), buckets: buckets}
+		return next.ServeHTTPCtx(ctx, httpware.Expose(tw, w), r)
+	})
+}
+
+func (t *Throttle) acquire(key string) *bucket {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(t.lastSweep) > time.Second {
+		// Forget the keys which are idle and have recovered their burst.
+		t.lastSweep = now
+		for k, kb := range t.keys {
+			if kb.refs == 0 && kb.full(now) {
+				delete(t.keys, k)
+			}
+		}
+	}
+	kb, ok := t.keys[key]
+	if !ok {
+		kb = &keyBucket{bucket: newBucket(t.conf.KeyRate, t.conf.Burst)}
+		t.keys[key] = kb
+	}
+	kb.refs++
+	return kb.bucket
+}
+
+func (t *Throttle) release(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.keys[key].refs--
+}
+
+// bucket is a token bucket where each token is a byte.
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst int64) *bucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// chunk is the largest write which can be paced as a whole.
+func (b *bucket) chunk() int {
+	return int(b.burst)
+}
+
+// reserve takes n tokens, going into debt if there are not enough, and
+// returns how long to wait before the debt is paid off.
+func (b *bucket) reserve(n int, now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// throttledWriter paces the writes to the underlying http.ResponseWriter. It
+// is exposed with httpware.Expose, so that it only claims the optional
+// interfaces which the underlying http.ResponseWriter implements.
+type throttledWriter struct {
+	http.ResponseWriter
+	// A paced write stops when either the context of the handler or the one
+	// of the request (canceled when the client goes away) is done.
+	ctx     context.Context
+	reqCtx  context.Context
+	buckets []*bucket
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	chunk := len(p)
+	for _, b := range w.buckets {
+		if c := b.chunk(); c > 0 && c < chunk {
+			chunk = c
+		}
+	}
+	written := 0
+	for len(p) > 0 {
+		n := chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) wait(n int) error {
+	now := time.Now()
+	var delay time.Duration
+	for _, b := range w.buckets {
+		if d := b.reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-w.reqCtx.Done():
+		return w.reqCtx.Err()
+	}
+}
+
+// ReadFrom copies through the paced Write method.
+func (w *throttledWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Flush fulfills the http.Flusher interface.
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify fulfills the http.CloseNotifier interface.
+func (w *throttledWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// Hijack fulfills the http.Hijacker interface. The hijacked connection is not
+// throttled.
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("limitware: the http.ResponseWriter does not support hijacking")
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,101 @@
+package limitware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestThrottle(t *testing.T) {
+	th := NewThrottle(ThrottleConfig{
+		KeyRate: 10000,
+		Burst:   1000,
+	})
+	payload := bytes.Repeat([]byte("a"), 3000)
+	h := httpware.Compose(httpware.DefaultErrHandler, th).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Fatal("expected throttled writer to be a http.Flusher")
+		}
+		if _, ok := w.(http.Hijacker); ok {
+			t.Fatal("expected throttled writer not to be a http.Hijacker")
+		}
+		rf, ok := w.(io.ReaderFrom)
+		if !ok {
+			t.Fatal("expected throttled writer to be an io.ReaderFrom")
+		}
+		_, err := rf.ReadFrom(bytes.NewReader(payload))
+		return err
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	elapsed := time.Since(start)
+	if !bytes.Equal(rec.Body.Bytes(), payload) {
+		t.Fatalf("expected %v bytes to be written, got %v", len(payload), rec.Body.Len())
+	}
+	// The burst is sent right away, the rest at the rate.
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("expected response to be throttled, took %v", elapsed)
+	}
+}
+
+func TestThrottleContext(t *testing.T) {
+	th := NewThrottle(ThrottleConfig{
+		KeyRate: 1000,
+		Burst:   1000,
+	})
+	var err error
+	h := th.Handle(httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		_, err = w.Write(bytes.Repeat([]byte("a"), 3000))
+		return nil
+	}))
+	// The context is canceled, the request context is not.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTPCtx(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err != context.Canceled {
+		t.Fatalf("expected the write to stop with the context, got %v", err)
+	}
+}
+
+func TestThrottleRequestContext(t *testing.T) {
+	th := NewThrottle(ThrottleConfig{
+		KeyRate: 1000,
+		Burst:   1000,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	var err error
+	h := httpware.Compose(httpware.DefaultErrHandler, th).ThenFunc(func(_ context.Context, w http.ResponseWriter, r *http.Request) error {
+		// The client goes away while the write is paced.
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err = w.Write(bytes.Repeat([]byte("a"), 10000))
+		return nil
+	})
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if err != context.Canceled {
+		t.Fatalf("expected the write to stop with the request context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the write to stop right away, took %v", elapsed)
+	}
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	th := NewThrottle(ThrottleConfig{})
+	rec := httptest.NewRecorder()
+	h := httpware.Compose(httpware.DefaultErrHandler, th).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if w != http.ResponseWriter(rec) {
+			t.Fatal("expected the http.ResponseWriter to be passed on untouched")
+		}
+		return nil
+	})
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+}
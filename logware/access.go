@@ -0,0 +1,316 @@
+package logware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+const (
+	// CommonLogFormat is the NCSA Common Log Format.
+	CommonLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+	// CombinedLogFormat is the Apache/NCSA Combined Log Format.
+	CombinedLogFormat = CommonLogFormat + ` "$http_referer" "$http_user_agent"`
+)
+
+// AccessConfig is used to initialize a new instance of AccessLog.
+type AccessConfig struct {
+	// Where the lines are written, ie: os.Stdout or a RotatingFile.
+	Out io.Writer
+	// The format of each line, using nginx style variables. Defaults to
+	// CommonLogFormat. The supported variables are:
+	//
+	//	$remote_addr      IP address of the client
+	//	$remote_user      user name given with basic authentication
+	//	$time_local       time of the request in Common Log Format
+	//	$time_iso8601     time of the request in ISO 8601 format
+	//	$request          request line, ie: "GET /users?page=2 HTTP/1.1"
+	//	$request_method   request method
+	//	$request_uri      path and query
+	//	$uri              path
+	//	$args             query
+	//	$server_protocol  protocol, ie: "HTTP/1.1"
+	//	$host             host the request was made to
+	//	$status           status code
+	//	$body_bytes_sent  size of the response body
+	//	$request_length   size of the request body
+	//	$request_time     duration in seconds with millisecond resolution
+	//	$http_NAME        request header, ie: $http_user_agent for 'User-Agent'
+	//
+	// Missing values are written as "-". Quotes, backslashes and control
+	// characters in values are escaped as Apache does (\", \\, \xHH).
+	// Variable names may be surrounded by braces, ie: ${status}.
+	Format string
+	// The size of the write buffer in bytes. Zero writes every line
+	// immediately.
+	BufferSize int
+	// How often buffered lines are written out. Defaults to a second.
+	FlushInterval time.Duration
+	// Redact lists the headers and query parameters whose values are
+	// replaced by "REDACTED" in $http_NAME, $args, $request_uri and
+	// $request. Redaction.Fields is not used.
+	Redact Redaction
+}
+
+// AccessLog is middleware which writes a line per request in an access log
+// format such as the Common or Combined Log Format.
+type AccessLog struct {
+	format []accessSegment
+	redact redactor
+
+	mutex sync.Mutex
+	out   io.Writer
+	buf   *bufio.Writer
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// accessSegment is either literal text or a variable.
+type accessSegment struct {
+	literal string
+	value   func(*accessEntry) string
+}
+
+// accessEntry holds what is known about a request once it has been served.
+type accessEntry struct {
+	r        *http.Request
+	redact   *redactor
+	start    time.Time
+	duration time.Duration
+	status   int
+	written  int64
+	read     int64
+}
+
+// NewAccessLog returns a new instance of AccessLog. It returns an error if
+// the format contains unknown variables.
+func NewAccessLog(conf AccessConfig) (*AccessLog, error) {
+	if conf.Format == "" {
+		conf.Format = CommonLogFormat
+	}
+	format, err := parseAccessFormat(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+	a := &AccessLog{
+		format: format,
+		redact: newRedactor(conf.Redact),
+		out:    conf.Out,
+	}
+	if conf.BufferSize > 0 {
+		if conf.FlushInterval == 0 {
+			conf.FlushInterval = time.Second
+		}
+		a.buf = bufio.NewWriterSize(conf.Out, conf.BufferSize)
+		a.out = a.buf
+		a.stop = make(chan struct{})
+		a.done = make(chan struct{})
+		go a.run(conf.FlushInterval)
+	}
+	return a, nil
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (a *AccessLog) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		sw := httpware.WrapWriter(w)
+		var body *countingReader
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r = r.WithContext(r.Context())
+			r.Body = body
+		}
+		e := accessEntry{r: r, redact: &a.redact, start: time.Now()}
+
+		err := next.ServeHTTPCtx(ctx, sw.Writer(), r)
+
+		e.duration = time.Since(e.start)
+		e.status = sw.Status()
+		if e.status == 0 {
+			e.status = httpware.StatusOf(err)
+		}
+		e.written = sw.Written()
+		if body != nil {
+			e.read = body.n
+		}
+		a.write(&e)
+		return err
+	})
+}
+
+// Flush writes out any buffered lines.
+func (a *AccessLog) Flush() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.buf == nil {
+		return nil
+	}
+	return a.buf.Flush()
+}
+
+// Close stops the periodic flushing and writes out any buffered lines. It
+// does not close Out.
+func (a *AccessLog) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		<-a.done
+	}
+	return a.Flush()
+}
+
+func (a *AccessLog) run(interval time.Duration) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Flush()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *AccessLog) write(e *accessEntry) {
+	var line strings.Builder
+	for _, s := range a.format {
+		if s.value == nil {
+			line.WriteString(s.literal)
+			continue
+		}
+		v := s.value(e)
+		if v == "" {
+			v = "-"
+		}
+		writeEscaped(&line, v)
+	}
+	line.WriteByte('\n')
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	io.WriteString(a.out, line.String())
+}
+
+// writeEscaped writes the value of a variable the way Apache does: quotes
+// and backslashes are escaped with a backslash, control characters and bytes
+// outside of ASCII as \xHH. Values from the client can not end the quoted
+// field or the line.
+func writeEscaped(b *strings.Builder, v string) {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			b.WriteString(`\x`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// requestURI returns the path and the redacted query.
+func (e *accessEntry) requestURI() string {
+	u := *e.r.URL
+	u.RawQuery = e.redact.rawQuery(u.RawQuery)
+	return u.RequestURI()
+}
+
+var accessVariables = map[string]func(*accessEntry) string{
+	"remote_addr": func(e *accessEntry) string {
+		host, _, err := net.SplitHostPort(e.r.RemoteAddr)
+		if err != nil {
+			return e.r.RemoteAddr
+		}
+		return host
+	},
+	"remote_user": func(e *accessEntry) string {
+		user, _, _ := e.r.BasicAuth()
+		return user
+	},
+	"time_local":      func(e *accessEntry) string { return e.start.Format("02/Jan/2006:15:04:05 -0700") },
+	"time_iso8601":    func(e *accessEntry) string { return e.start.Format(time.RFC3339) },
+	"request":         func(e *accessEntry) string { return e.r.Method + " " + e.requestURI() + " " + e.r.Proto },
+	"request_method":  func(e *accessEntry) string { return e.r.Method },
+	"request_uri":     func(e *accessEntry) string { return e.requestURI() },
+	"uri":             func(e *accessEntry) string { return e.r.URL.Path },
+	"args":            func(e *accessEntry) string { return e.redact.rawQuery(e.r.URL.RawQuery) },
+	"server_protocol": func(e *accessEntry) string { return e.r.Proto },
+	"host":            func(e *accessEntry) string { return e.r.Host },
+	"status":          func(e *accessEntry) string { return strconv.Itoa(e.status) },
+	"body_bytes_sent": func(e *accessEntry) string { return strconv.FormatInt(e.written, 10) },
+	"request_length":  func(e *accessEntry) string { return strconv.FormatInt(e.read, 10) },
+	"request_time": func(e *accessEntry) string {
+		return strconv.FormatFloat(e.duration.Seconds(), 'f', 3, 64)
+	},
+}
+
+func parseAccessFormat(format string) ([]accessSegment, error) {
+	var segments []accessSegment
+	literal := ""
+	for i := 0; i < len(format); {
+		if format[i] != '$' {
+			literal += format[i : i+1]
+			i++
+			continue
+		}
+		var name string
+		if strings.HasPrefix(format[i+1:], "{") {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("logware: unterminated variable in access log format: %q", format[i:])
+			}
+			name = format[i+2 : i+end]
+			i += end + 1
+		} else {
+			j := i + 1
+			for j < len(format) && isVariableChar(format[j]) {
+				j++
+			}
+			name = format[i+1 : j]
+			i = j
+		}
+		value, err := accessVariable(name)
+		if err != nil {
+			return nil, err
+		}
+		if literal != "" {
+			segments = append(segments, accessSegment{literal: literal})
+			literal = ""
+		}
+		segments = append(segments, accessSegment{value: value})
+	}
+	if literal != "" {
+		segments = append(segments, accessSegment{literal: literal})
+	}
+	return segments, nil
+}
+
+func accessVariable(name string) (func(*accessEntry) string, error) {
+	if v, ok := accessVariables[name]; ok {
+		return v, nil
+	}
+	if strings.HasPrefix(name, "http_") && len(name) > len("http_") {
+		header := http.CanonicalHeaderKey(strings.Replace(name[len("http_"):], "_", "-", -1))
+		return func(e *accessEntry) string { return e.redact.header(header, e.r.Header.Get(header)) }, nil
+	}
+	return nil, fmt.Errorf("logware: unknown variable in access log format: $%s", name)
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,125 @@
+package logware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func accessHandler(a *AccessLog) httpware.CompositeHandler {
+	return httpware.Compose(httpware.DefaultErrHandler, a).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/missing" {
+			return httpware.NewErr("not found", http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+		return nil
+	})
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	cases := []struct {
+		Format   string
+		Path     string
+		Expected string
+	}{
+		{
+			Format:   CommonLogFormat,
+			Path:     "/users?page=2",
+			Expected: `^192\.0\.2\.1 - bob \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users\?page=2 HTTP/1\.1" 200 5\n$`,
+		},
+		{
+			Format:   CombinedLogFormat,
+			Path:     "/missing",
+			Expected: `^192\.0\.2\.1 - bob \[.+\] "GET /missing HTTP/1\.1" 404 0 "http://example\.com/" "tester"\n$`,
+		},
+		{
+			Format:   `$request_method ${uri}?$args id=$http_x_request_id t=$request_time none=$http_x_missing`,
+			Path:     "/users?page=2",
+			Expected: `^GET /users\?page=2 id=abc t=\d+\.\d{3} none=-\n$`,
+		},
+	}
+	for _, c := range cases {
+		var buffer bytes.Buffer
+		a, err := NewAccessLog(AccessConfig{Out: &buffer, Format: c.Format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", c.Path, nil)
+		req.SetBasicAuth("bob", "secret")
+		req.Header.Set("Referer", "http://example.com/")
+		req.Header.Set("User-Agent", "tester")
+		req.Header.Set("X-Request-ID", "abc")
+		accessHandler(a).ServeHTTP(httptest.NewRecorder(), req)
+		if !regexp.MustCompile(c.Expected).MatchString(buffer.String()) {
+			t.Fatalf("expected line to match %s, got: %s", c.Expected, buffer.String())
+		}
+	}
+}
+
+func TestAccessLogUnknownVariable(t *testing.T) {
+	if _, err := NewAccessLog(AccessConfig{Format: "$status $bogus"}); err == nil {
+		t.Fatal("expected an error for an unknown variable")
+	}
+	if _, err := NewAccessLog(AccessConfig{Format: "${status"}); err == nil {
+		t.Fatal("expected an error for an unterminated variable")
+	}
+}
+
+func TestAccessLogBuffering(t *testing.T) {
+	var buffer bytes.Buffer
+	a, err := NewAccessLog(AccessConfig{Out: &buffer, BufferSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessHandler(a).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if buffer.Len() != 0 {
+		t.Fatalf("expected line to be buffered, got: %s", buffer.String())
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `"GET / HTTP/1.1" 200 5`) {
+		t.Fatalf("expected line to be written on close, got: %s", buffer.String())
+	}
+}
+
+func TestAccessLogRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	a, err := NewAccessLog(AccessConfig{
+		Out:    &buf,
+		Format: `"$request" $args $http_authorization`,
+		Redact: Redaction{Query: []string{"key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/items?key=abc&q=x", nil)
+	r.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	accessHandler(a).ServeHTTP(httptest.NewRecorder(), r)
+
+	expected := `"GET /items?key=REDACTED&q=x HTTP/1.1" key=REDACTED&q=x REDACTED` + "\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestAccessLogEscaping(t *testing.T) {
+	var buffer bytes.Buffer
+	a, err := NewAccessLog(AccessConfig{Out: &buffer, Format: CombinedLogFormat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "evil\" \\ \n127.0.0.1 - - [forged]\xff")
+	accessHandler(a).ServeHTTP(httptest.NewRecorder(), req)
+	expected := `"evil\" \\ \x0a127.0.0.1 - - [forged]\xff"` + "\n"
+	if !strings.HasSuffix(buffer.String(), expected) {
+		t.Fatalf("expected the user agent to be escaped, got: %s", buffer.String())
+	}
+}
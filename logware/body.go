@@ -0,0 +1,224 @@
+package logware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/nstogner/httpware"
+)
+
+// DefaultBodyContentTypes are captured when BodyCapture.ContentTypes is nil.
+var DefaultBodyContentTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/x-www-form-urlencoded",
+	"text/*",
+}
+
+// BodyCapture configures the logging of request and response bodies, which is
+// meant for debugging. The captured bodies are added to the end of request
+// entries as "requestBody" and "responseBody".
+type BodyCapture struct {
+	// Whether bodies are captured when the middleware is created. It can be
+	// changed at runtime with Middle.CaptureBodies.
+	Enabled bool
+	// The maximum number of bytes captured of each body. Defaults to 4096,
+	// a negative value captures nothing. Longer bodies are cut off and
+	// flagged with "requestBodyTruncated" or "responseBodyTruncated".
+	MaxBytes int
+	// The media types which are captured, a subtype of "*" matches any
+	// subtype. Defaults to DefaultBodyContentTypes. Bodies of other types,
+	// such as images, are skipped.
+	ContentTypes []string
+	// The values of JSON object keys with these names are replaced by
+	// "REDACTED", at any depth. Names are matched case-insensitively.
+	MaskFields []string
+}
+
+// bodyCapturer is the compiled form of a BodyCapture.
+type bodyCapturer struct {
+	maxBytes     int
+	contentTypes []string
+	mask         map[string]bool
+	maskPattern  *regexp.Regexp
+}
+
+func newBodyCapturer(conf BodyCapture) *bodyCapturer {
+	if conf.MaxBytes == 0 {
+		conf.MaxBytes = 4096
+	}
+	if conf.MaxBytes < 0 {
+		conf.MaxBytes = 0
+	}
+	if conf.ContentTypes == nil {
+		conf.ContentTypes = DefaultBodyContentTypes
+	}
+	c := &bodyCapturer{
+		maxBytes:     conf.MaxBytes,
+		contentTypes: conf.ContentTypes,
+		mask:         lowerSet(conf.MaskFields),
+	}
+	if len(conf.MaskFields) > 0 {
+		// Used for bodies which were cut off and can not be parsed.
+		names := make([]string, len(conf.MaskFields))
+		for i, n := range conf.MaskFields {
+			names[i] = regexp.QuoteMeta(n)
+		}
+		c.maskPattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+	}
+	return c
+}
+
+// captures reports whether bodies with the given Content-Type are captured.
+func (c *bodyCapturer) captures(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.contentTypes {
+		if t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// addFields adds the captured body to the log entry fields.
+func (c *bodyCapturer) addFields(fields Fields, name string, body *capturedBody) {
+	if body == nil || !body.capturing {
+		return
+	}
+	fields[name] = c.masked(body.contentType, body.buf.Bytes(), body.truncated)
+	if body.truncated {
+		fields[name+"Truncated"] = true
+	}
+}
+
+// masked returns the body as a string with the MaskFields redacted.
+func (c *bodyCapturer) masked(contentType string, body []byte, truncated bool) string {
+	if len(c.mask) == 0 || !isJSON(contentType) {
+		return string(body)
+	}
+	if !truncated {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if masked, err := json.Marshal(c.maskValue(v)); err == nil {
+				return string(masked)
+			}
+		}
+	}
+	return c.maskPattern.ReplaceAllString(string(body), `${1}"`+Redacted+`"`)
+}
+
+func (c *bodyCapturer) maskValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, elem := range v {
+			if c.mask[strings.ToLower(k)] {
+				v[k] = Redacted
+			} else {
+				v[k] = c.maskValue(elem)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = c.maskValue(elem)
+		}
+	}
+	return v
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// capturedBody keeps the first bytes of a body.
+type capturedBody struct {
+	max         int
+	contentType string
+	capturing   bool
+	truncated   bool
+	buf         bytes.Buffer
+}
+
+func (b *capturedBody) capture(p []byte) {
+	if !b.capturing {
+		return
+	}
+	if room := b.max - b.buf.Len(); len(p) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+}
+
+// captureReader tees what is read from a request body.
+type captureReader struct {
+	io.ReadCloser
+	body *capturedBody
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.body.capture(p[:n])
+	return n, err
+}
+
+// captureWriter tees what is written to a response. It embeds the
+// StatusWriter for the methods of the optional interfaces, which are exposed
+// with httpware.Expose.
+type captureWriter struct {
+	*httpware.StatusWriter
+	capturer *bodyCapturer
+	body     *capturedBody
+	decided  bool
+}
+
+// decide determines on the first write whether the response is captured.
+func (w *captureWriter) decide(p []byte) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	contentType := w.Header().Get("Content-Type")
+	if contentType == "" && p != nil {
+		contentType = http.DetectContentType(p)
+	}
+	w.body.contentType = contentType
+	w.body.capturing = w.capturer.captures(contentType)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.decide(p)
+	n, err := w.StatusWriter.Write(p)
+	w.body.capture(p[:n])
+	return n, err
+}
+
+// ReadFrom passes the reader on untouched when the response is not captured,
+// so sendfile can still be used.
+func (w *captureWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.decide(nil)
+	if !w.body.capturing {
+		return w.StatusWriter.ReadFrom(r)
+	}
+	return w.StatusWriter.ReadFrom(io.TeeReader(r, writerFunc(func(p []byte) (int, error) {
+		w.body.capture(p)
+		return len(p), nil
+	})))
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,103 @@
+package logware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestBodyCapture(t *testing.T) {
+	rec := &recorder{}
+	m := New(Config{
+		Logger: rec,
+		End:    true,
+		Body: BodyCapture{
+			MaxBytes:   64,
+			MaskFields: []string{"password"},
+		},
+	})
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		case "/long":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"password":"hunter2","padding":"` + strings.Repeat("x", 100) + `"}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1,"user":{"Password":"hunter2"}}`))
+		}
+		return nil
+	})
+	serve := func(path, body string) Fields {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		entries := rec.reset()
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry, got %v", len(entries))
+		}
+		return entries[0].fields
+	}
+
+	// Disabled by default.
+	if f := serve("/", `{}`); f["requestBody"] != nil || f["responseBody"] != nil {
+		t.Fatalf("expected no bodies while disabled, got %v", f)
+	}
+
+	m.CaptureBodies(true)
+	f := serve("/", `{"name":"bob","password":"secret"}`)
+	if expected := `{"name":"bob","password":"REDACTED"}`; f["requestBody"] != expected {
+		t.Fatalf("expected request body %s, got %v", expected, f["requestBody"])
+	}
+	if expected := `{"id":1,"user":{"Password":"REDACTED"}}`; f["responseBody"] != expected {
+		t.Fatalf("expected response body %s, got %v", expected, f["responseBody"])
+	}
+
+	f = serve("/image", `{}`)
+	if _, ok := f["responseBody"]; ok {
+		t.Fatalf("expected binary response to be skipped, got %v", f["responseBody"])
+	}
+
+	f = serve("/long", `{}`)
+	got, _ := f["responseBody"].(string)
+	if len(got) > 64+len(Redacted) || f["responseBodyTruncated"] != true {
+		t.Fatalf("expected a truncated response body, got %q", got)
+	}
+	if strings.Contains(got, "hunter2") || !strings.Contains(got, `"password":"REDACTED"`) {
+		t.Fatalf("expected the truncated body to be masked, got %q", got)
+	}
+
+	m.CaptureBodies(false)
+	if f := serve("/", `{}`); f["requestBody"] != nil {
+		t.Fatalf("expected no bodies after disabling, got %v", f)
+	}
+}
+
+func TestBodyCaptureNegativeMax(t *testing.T) {
+	rec := &recorder{}
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Logger: rec,
+		End:    true,
+		Body:   BodyCapture{Enabled: true, MaxBytes: -1},
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+		return nil
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	entries := rec.reset()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", len(entries))
+	}
+	if body := entries[0].fields["responseBody"]; body != nil && body != "" {
+		t.Fatalf("expected nothing to be captured, got %v", body)
+	}
+}
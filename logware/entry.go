@@ -0,0 +1,77 @@
+package logware
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nstogner/httpware"
+)
+
+// Entry is a request-scoped logger which is stored in the context by the
+// middleware. Its entries carry the fields of the request, such as the method
+// and path. Fields added with AddField are also included in the end of
+// request entry. All methods are safe to call on a nil *Entry, they do
+// nothing.
+type Entry struct {
+	logger Logger
+
+	mutex  sync.Mutex
+	fields Fields
+}
+
+// LoggerFromCtx returns the request-scoped logger, or nil if the request is
+// not served through the middleware.
+func LoggerFromCtx(ctx context.Context) *Entry {
+	e, _ := ctx.Value(httpware.LoggerKey).(*Entry)
+	return e
+}
+
+// AddField adds a field to this entry and to the end of request entry, ie:
+// the ID of the authenticated user.
+func (e *Entry) AddField(key string, value interface{}) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.fields[key] = value
+}
+
+// Log writes an entry with the request's fields and the given fields, which
+// take precedence.
+func (e *Entry) Log(level Level, msg string, fields Fields) {
+	if e == nil {
+		return
+	}
+	all := e.Fields()
+	for k, v := range fields {
+		all[k] = v
+	}
+	e.logger.Log(level, msg, all)
+}
+
+// Debug logs at DebugLevel.
+func (e *Entry) Debug(msg string, fields Fields) { e.Log(DebugLevel, msg, fields) }
+
+// Info logs at InfoLevel.
+func (e *Entry) Info(msg string, fields Fields) { e.Log(InfoLevel, msg, fields) }
+
+// Warn logs at WarnLevel.
+func (e *Entry) Warn(msg string, fields Fields) { e.Log(WarnLevel, msg, fields) }
+
+// Error logs at ErrorLevel.
+func (e *Entry) Error(msg string, fields Fields) { e.Log(ErrorLevel, msg, fields) }
+
+// Fields returns a copy of the request's fields.
+func (e *Entry) Fields() Fields {
+	if e == nil {
+		return Fields{}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	fields := make(Fields, len(e.fields))
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	return fields
+}
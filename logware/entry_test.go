@@ -0,0 +1,68 @@
+package logware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestLoggerFromCtx(t *testing.T) {
+	rec := &recorder{}
+	m := httpware.Compose(httpware.DefaultErrHandler, New(Config{Logger: rec, End: true}))
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		l := LoggerFromCtx(ctx)
+		l.AddField("userId", "u-1")
+		l.Warn("looking up user", Fields{"attempt": 1})
+		return nil
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	entries := rec.reset()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", len(entries))
+	}
+	handler, end := entries[0], entries[1]
+	if handler.level != WarnLevel || handler.msg != "looking up user" {
+		t.Fatalf("unexpected handler entry: %v", handler)
+	}
+	for _, f := range []Fields{handler.fields, end.fields} {
+		if f["method"] != "GET" || f["path"] != "/users" || f["userId"] != "u-1" {
+			t.Fatalf("expected request fields, got %v", f)
+		}
+	}
+	if handler.fields["attempt"] != 1 {
+		t.Fatalf("expected handler field, got %v", handler.fields)
+	}
+	if _, ok := end.fields["attempt"]; ok {
+		t.Fatal("expected handler entry fields to stay out of the end entry")
+	}
+}
+
+func TestNilEntry(t *testing.T) {
+	l := LoggerFromCtx(context.Background())
+	if l != nil {
+		t.Fatal("expected no logger outside of the middleware")
+	}
+	// Must not panic.
+	l.AddField("a", 1)
+	l.Info("msg", nil)
+	if len(l.Fields()) != 0 {
+		t.Fatal("expected no fields")
+	}
+}
+
+func TestRequestIDField(t *testing.T) {
+	rec := &recorder{}
+	h := New(Config{Logger: rec, End: true}).Handle(httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}))
+	ctx := context.WithValue(context.Background(), httpware.RequestIDKey, "req-1")
+	h.ServeHTTPCtx(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	entries := rec.reset()
+	if len(entries) != 1 || entries[0].fields["requestId"] != "req-1" {
+		t.Fatalf("expected the request ID to be logged, got %v", entries)
+	}
+}
@@ -1,12 +1,25 @@
 /*
 Package logware provides http middleware for logging requests and errors. It
-is based on the httpware.Middleware interface.
+is based on the httpware.Middleware interface. Entries are written through the
+Logger interface, adapters are provided for logrus and log/slog. The end of
+request entries include the status code that was written, the duration and
+the sizes of the request and response. Sensitive headers, query parameters
+and error fields can be redacted, and successful requests can be sampled.
+For debugging, the request and response bodies can be captured as well.
+
+Handlers can log with the request's fields through LoggerFromCtx, and add
+fields to the end of request entry, ie:
+
+	logware.LoggerFromCtx(ctx).AddField("userId", id)
 */
 package logware
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nstogner/httpware"
@@ -15,7 +28,7 @@ import (
 var (
 	// Defaults is a reasonable configuration that should work for most cases.
 	Defaults = Config{
-		Logger: logrus.New(),
+		Logger: NewLogrus(logrus.New()),
 		Start:  true,
 		End:    true,
 	}
@@ -23,27 +36,90 @@ var (
 
 // Config is used to initialize a new instance of this middleware.
 type Config struct {
-	Logger         *logrus.Logger
-	Headers        []string
-	Referer        bool
-	RemoteAddr     bool
+	Logger Logger
+	// Request headers to log. Values are redacted according to Redact.
+	Headers    []string
+	Referer    bool
+	RemoteAddr bool
+	// Log the query string, with the values listed in Redact.Query redacted.
+	Query          bool
 	IgnoreUnder400 bool
 	Ignore4XX      bool
 	// Log when the request comes in.
 	Start bool
 	// Log at the end of the request.
 	End bool
+	// The level of the end of request entries for requests which succeeded.
+	// Defaults to InfoLevel. The start of request entries also use it.
+	SuccessLevel Level
+	// The level of the end of request entries for client errors (4XX).
+	// Defaults to InfoLevel.
+	ClientErrorLevel Level
+	// The level of the end of request entries for server errors (5XX) and
+	// of panics. Defaults to ErrorLevel.
+	ErrorLevel Level
+	// Redact lists the headers, query parameters and httpware.Err.Fields
+	// keys whose values are replaced by "REDACTED".
+	Redact Redaction
+	// The fraction of successful requests which are logged, ie: 0.1 logs one
+	// in ten. Zero logs them all. Errors are always logged.
+	SampleRate float64
+	// The maximum number of successful requests logged per second. Zero
+	// disables this limit. Errors are always logged.
+	SampleLimit int
+	// Overrides SuccessLevel for paths starting with the given prefixes, the
+	// longest prefix wins. Use OffLevel to silence a path, ie:
+	// {"/healthz": OffLevel}. Errors are logged regardless.
+	PathLevels map[string]Level
+	// Capture request and response bodies, see BodyCapture.
+	Body BodyCapture
 }
 
 // Middle logs http responses and any errors returned by the downstream
 // handler.
 type Middle struct {
-	conf Config
+	conf    Config
+	redact  redactor
+	sampler *sampler
+	body    *bodyCapturer
+	// Whether bodies are captured, accessed atomically.
+	capture int32
 }
 
 // New returns a new logware.Middle instance.
 func New(conf Config) *Middle {
-	return &Middle{conf}
+	if conf.SuccessLevel == 0 {
+		conf.SuccessLevel = InfoLevel
+	}
+	if conf.ClientErrorLevel == 0 {
+		conf.ClientErrorLevel = InfoLevel
+	}
+	if conf.ErrorLevel == 0 {
+		conf.ErrorLevel = ErrorLevel
+	}
+	m := &Middle{
+		conf:    conf,
+		redact:  newRedactor(conf.Redact),
+		sampler: newSampler(conf.SampleRate, conf.SampleLimit),
+		body:    newBodyCapturer(conf.Body),
+	}
+	m.CaptureBodies(conf.Body.Enabled)
+	return m
+}
+
+// CaptureBodies turns the capture of request and response bodies on or off.
+// It is safe to call while requests are being served.
+func (m *Middle) CaptureBodies(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.capture, v)
+}
+
+// CapturingBodies reports whether request and response bodies are captured.
+func (m *Middle) CapturingBodies() bool {
+	return atomic.LoadInt32(&m.capture) == 1
 }
 
 // Handle takes the next handler as an argument and wraps it in this middleware.
@@ -52,71 +128,142 @@ func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
 		// Log panics.
 		defer func() {
 			if rcv := recover(); rcv != nil {
-				m.conf.Logger.WithField("error", rcv).Error("handler panic detected")
+				m.conf.Logger.Log(m.conf.ErrorLevel, "handler panic detected", Fields{"error": rcv})
 				// Pass on the panic.
 				panic(rcv)
 			}
 		}()
 
 		// Always log the method and path.
-		entry := m.conf.Logger.WithFields(logrus.Fields{
+		fields := Fields{
 			"method": r.Method,
 			"path":   r.URL.Path,
-		})
+		}
 
 		// Conditional logging...
 		if m.conf.Referer {
-			entry = entry.WithField("referrer", r.Referer())
+			fields["referrer"] = r.Referer()
 		}
 		if m.conf.RemoteAddr {
-			entry = entry.WithField("remoteAddr", r.RemoteAddr)
+			fields["remoteAddr"] = r.RemoteAddr
+		}
+		if id, ok := ctx.Value(httpware.RequestIDKey).(string); ok {
+			fields["requestId"] = id
+		}
+		if m.conf.Query {
+			fields["query"] = m.redact.rawQuery(r.URL.RawQuery)
 		}
 		for _, h := range m.conf.Headers {
-			entry = entry.WithField(h, r.Header.Get(h))
+			fields[h] = m.redact.header(h, r.Header.Get(h))
+		}
+
+		// Successful requests are only logged when sampled and when their
+		// path is not silenced.
+		successLevel := m.conf.SuccessLevel
+		if l, ok := pathLevel(m.conf.PathLevels, r.URL.Path); ok {
+			successLevel = l
 		}
+		logSuccess := successLevel != OffLevel && m.sampler.sample()
 
-		if m.conf.Start {
-			entry.Info("new request")
+		if m.conf.Start && logSuccess {
+			m.conf.Logger.Log(successLevel, "new request", fields)
 		}
 
+		// Record the response and count the bytes read from the body.
+		sw := httpware.WrapWriter(w)
+		rw := sw.Writer()
+		var body *countingReader
+		var reqBody, respBody *capturedBody
+		if m.conf.End && m.CapturingBodies() {
+			reqBody = &capturedBody{max: m.body.maxBytes, contentType: r.Header.Get("Content-Type")}
+			reqBody.capturing = r.Body != nil && m.body.captures(reqBody.contentType)
+			respBody = &capturedBody{max: m.body.maxBytes}
+			rw = httpware.Expose(&captureWriter{StatusWriter: sw, capturer: m.body, body: respBody}, sw.ResponseWriter)
+		}
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			if reqBody != nil {
+				body.ReadCloser = &captureReader{ReadCloser: r.Body, body: reqBody}
+			}
+			r = r.WithContext(r.Context())
+			r.Body = body
+		}
+		start := time.Now()
+
+		// Make the request-scoped logger available downstream.
+		entry := &Entry{logger: m.conf.Logger, fields: fields}
+		ctx = context.WithValue(ctx, httpware.LoggerKey, entry)
+
 		// Call downstream handlers.
-		err := next.ServeHTTPCtx(ctx, w, r)
+		err := next.ServeHTTPCtx(ctx, rw, r)
 
 		if m.conf.End {
+			fields := entry.Fields()
+			fields["durationMs"] = float64(time.Since(start)) / float64(time.Millisecond)
+			fields["proto"] = r.Proto
+			fields["responseSize"] = sw.Written()
+			requestSize := r.ContentLength
+			if body != nil && body.n > requestSize {
+				requestSize = body.n
+			}
+			if requestSize < 0 {
+				requestSize = 0
+			}
+			fields["requestSize"] = requestSize
+			if route, ok := ctx.Value(httpware.RouteKey).(string); ok {
+				fields["route"] = route
+			}
+			m.body.addFields(fields, "requestBody", reqBody)
+			m.body.addFields(fields, "responseBody", respBody)
+
 			// Add any errors to the log entry.
-			statusCode := 0
+			statusCode := http.StatusOK
 			if err != nil {
 				if httpErr, ok := err.(httpware.Err); ok {
-					entry = entry.WithFields(logrus.Fields{
-						"statusCode": httpErr.StatusCode,
-						"message":    httpErr.Message,
-					})
-					entry = entry.WithFields(httpErr.Fields)
+					fields["message"] = httpErr.Message
+					for k, v := range httpErr.Fields {
+						fields[k] = m.redact.field(k, v)
+					}
 					statusCode = httpErr.StatusCode
 				} else {
-					entry = entry.WithField("error",
-						map[string]interface{}{
-							"statusCode": http.StatusInternalServerError,
-							"message":    err,
-						},
-					)
+					fields["error"] = map[string]interface{}{
+						"statusCode": http.StatusInternalServerError,
+						"message":    err,
+					}
 					statusCode = http.StatusInternalServerError
 				}
 			}
+			// The status which was actually written takes precedence.
+			if sw.Status() != 0 {
+				statusCode = sw.Status()
+			}
+			fields["statusCode"] = statusCode
 
 			// Log with the right level and pass on the error.
 			if statusCode >= 500 {
-				entry.Error("request resulted in server error")
+				m.conf.Logger.Log(m.conf.ErrorLevel, "request resulted in server error", fields)
 			} else {
 				if statusCode >= 400 {
 					if !m.conf.Ignore4XX {
-						entry.Info("request resulted in client error")
+						m.conf.Logger.Log(m.conf.ClientErrorLevel, "request resulted in client error", fields)
 					}
-				} else if !m.conf.IgnoreUnder400 {
-					entry.Info("request successful")
+				} else if !m.conf.IgnoreUnder400 && logSuccess {
+					m.conf.Logger.Log(successLevel, "request successful", fields)
 				}
 			}
 		}
 		return err
 	})
 }
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -3,18 +3,24 @@ package logware
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/nstogner/httpware"
 )
 
 func TestLog(t *testing.T) {
 	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buffer
 	conf := Defaults
-	conf.Logger.Out = &buffer
+	conf.Logger = NewLogrus(logger)
 	m := httpware.Compose(
 		httpware.DefaultErrHandler,
 		New(conf),
@@ -65,3 +71,85 @@ func TestLog(t *testing.T) {
 		}
 	}
 }
+
+func TestSlog(t *testing.T) {
+	var buffer bytes.Buffer
+	conf := Defaults
+	conf.Logger = NewSlog(slog.New(slog.NewJSONHandler(&buffer, nil)))
+	conf.Start = false
+	conf.ClientErrorLevel = WarnLevel
+	m := httpware.Compose(
+		httpware.DefaultErrHandler,
+		New(conf),
+	)
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return httpware.NewErr("not here", http.StatusNotFound).WithField("resource", "user")
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single JSON entry, got: %s", buffer.String())
+	}
+	expected := map[string]interface{}{
+		"level":      "WARN",
+		"msg":        "request resulted in client error",
+		"method":     "GET",
+		"path":       "/users/1",
+		"statusCode": float64(http.StatusNotFound),
+		"message":    "not here",
+		"resource":   "user",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Fatalf("expected field %s to be %v, got: %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestEndFields(t *testing.T) {
+	var entries []Fields
+	conf := Defaults
+	conf.Start = false
+	conf.Logger = LoggerFunc(func(level Level, msg string, fields Fields) {
+		entries = append(entries, fields)
+	})
+	m := httpware.Compose(
+		httpware.DefaultErrHandler,
+		New(conf),
+	)
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+		return nil
+	})
+
+	req := httptest.NewRequest("POST", "/missing", strings.NewReader("abc"))
+	h.ServeHTTPCtx(context.WithValue(context.Background(), httpware.RouteKey, "/:name"), httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", len(entries))
+	}
+	expected := Fields{
+		"statusCode":   http.StatusNotFound,
+		"responseSize": int64(5),
+		"requestSize":  int64(3),
+		"route":        "/:name",
+		"proto":        "HTTP/1.1",
+	}
+	for k, v := range expected {
+		if entries[0][k] != v {
+			t.Fatalf("expected field %s to be %v, got: %v", k, v, entries[0][k])
+		}
+	}
+	if _, ok := entries[0]["durationMs"].(float64); !ok {
+		t.Fatalf("expected durationMs field, got: %v", entries[0])
+	}
+	if entries[1]["statusCode"] != http.StatusOK {
+		t.Fatalf("expected status code %v, got: %v", http.StatusOK, entries[1]["statusCode"])
+	}
+}
@@ -0,0 +1,46 @@
+package logware
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels. The zero value means that a default level should be used.
+const (
+	DebugLevel Level = iota + 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// OffLevel disables the entries it is used for, see Config.PathLevels.
+const OffLevel Level = -1
+
+var levelStrings = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	return levelStrings[l]
+}
+
+// Fields are the key-value pairs attached to a log entry.
+type Fields map[string]interface{}
+
+// Logger is the structured logging backend used by the middleware. Adapters
+// are provided for logrus (NewLogrus) and log/slog (NewSlog).
+type Logger interface {
+	// Log writes a single entry. Implementations must not retain or modify
+	// the fields after returning.
+	Log(level Level, msg string, fields Fields)
+}
+
+// LoggerFunc is an adapter to allow the use of ordinary functions as a
+// Logger.
+type LoggerFunc func(level Level, msg string, fields Fields)
+
+// Log calls f(level, msg, fields).
+func (f LoggerFunc) Log(level Level, msg string, fields Fields) {
+	f(level, msg, fields)
+}
@@ -0,0 +1,26 @@
+package logware
+
+import "github.com/Sirupsen/logrus"
+
+// NewLogrus returns a Logger which writes to a logrus.Logger.
+func NewLogrus(l *logrus.Logger) Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+func (ll logrusLogger) Log(level Level, msg string, fields Fields) {
+	entry := ll.l.WithFields(logrus.Fields(fields))
+	switch level {
+	case DebugLevel:
+		entry.Debug(msg)
+	case WarnLevel:
+		entry.Warn(msg)
+	case ErrorLevel:
+		entry.Error(msg)
+	default:
+		entry.Info(msg)
+	}
+}
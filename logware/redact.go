@@ -0,0 +1,143 @@
+package logware
+
+import (
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the values which are redacted from log entries.
+const Redacted = "REDACTED"
+
+// DefaultRedactedHeaders are redacted when Redaction.Headers is nil.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Redaction lists the values which must not appear in log entries. Names are
+// matched case-insensitively.
+type Redaction struct {
+	// Request headers. Defaults to DefaultRedactedHeaders, set to an empty
+	// slice to redact none.
+	Headers []string
+	// Query parameters.
+	Query []string
+	// Keys of httpware.Err.Fields.
+	Fields []string
+}
+
+// redactor is the compiled form of a Redaction.
+type redactor struct {
+	headers map[string]bool
+	query   map[string]bool
+	fields  map[string]bool
+}
+
+func newRedactor(rd Redaction) redactor {
+	if rd.Headers == nil {
+		rd.Headers = DefaultRedactedHeaders
+	}
+	return redactor{
+		headers: lowerSet(rd.Headers),
+		query:   lowerSet(rd.Query),
+		fields:  lowerSet(rd.Fields),
+	}
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	return set
+}
+
+func (rd redactor) header(name, value string) string {
+	if rd.headers[strings.ToLower(name)] {
+		return Redacted
+	}
+	return value
+}
+
+func (rd redactor) field(name string, value interface{}) interface{} {
+	if rd.fields[strings.ToLower(name)] {
+		return Redacted
+	}
+	return value
+}
+
+// rawQuery returns the query with the values of redacted parameters
+// replaced. The order of the parameters is kept.
+func (rd redactor) rawQuery(raw string) string {
+	if len(rd.query) == 0 || raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for i, p := range parts {
+		name := p
+		if eq := strings.IndexByte(p, '='); eq >= 0 {
+			name = p[:eq]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if rd.query[strings.ToLower(name)] {
+			parts[i] = url.QueryEscape(name) + "=" + Redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sampler decides which successful requests are logged.
+type sampler struct {
+	rate  float64
+	limit int
+
+	mutex  sync.Mutex
+	rnd    *rand.Rand
+	second int64
+	count  int
+}
+
+func newSampler(rate float64, limit int) *sampler {
+	return &sampler{
+		rate:  rate,
+		limit: limit,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// sample reports whether the request should be logged.
+func (s *sampler) sample() bool {
+	if (s.rate <= 0 || s.rate >= 1) && s.limit <= 0 {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rate > 0 && s.rate < 1 && s.rnd.Float64() >= s.rate {
+		return false
+	}
+	if s.limit > 0 {
+		now := time.Now().Unix()
+		if now != s.second {
+			s.second, s.count = now, 0
+		}
+		if s.count >= s.limit {
+			return false
+		}
+		s.count++
+	}
+	return true
+}
+
+// pathLevel returns the level for the longest prefix of path in levels.
+func pathLevel(levels map[string]Level, path string) (Level, bool) {
+	var level Level
+	longest := -1
+	for prefix, l := range levels {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			level, longest = l, len(prefix)
+		}
+	}
+	return level, longest >= 0
+}
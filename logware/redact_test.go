@@ -0,0 +1,171 @@
+package logware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+// recorder is a Logger which keeps the entries in memory.
+type recorder struct {
+	mutex   sync.Mutex
+	entries []recordedEntry
+}
+
+type recordedEntry struct {
+	level  Level
+	msg    string
+	fields Fields
+}
+
+func (rec *recorder) Log(level Level, msg string, fields Fields) {
+	copied := make(Fields, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.entries = append(rec.entries, recordedEntry{level, msg, copied})
+}
+
+func (rec *recorder) reset() []recordedEntry {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	entries := rec.entries
+	rec.entries = nil
+	return entries
+}
+
+func serveLogged(conf Config, r *http.Request) {
+	m := httpware.Compose(httpware.DefaultErrHandler, New(conf))
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/fail" {
+			return httpware.NewErr("failed", http.StatusBadRequest).WithField("password", "hunter2")
+		}
+		return nil
+	})
+	h.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestRedaction(t *testing.T) {
+	rec := &recorder{}
+	conf := Config{
+		Logger:  rec,
+		End:     true,
+		Query:   true,
+		Headers: []string{"Authorization", "X-Tenant"},
+		Redact: Redaction{
+			Query:  []string{"token"},
+			Fields: []string{"Password"},
+		},
+	}
+	r := httptest.NewRequest("GET", "/fail?page=2&token=s3cret", nil)
+	r.Header.Set("Authorization", "Bearer abc")
+	r.Header.Set("X-Tenant", "acme")
+	serveLogged(conf, r)
+
+	entries := rec.reset()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", len(entries))
+	}
+	f := entries[0].fields
+	expected := map[string]interface{}{
+		"Authorization": Redacted,
+		"X-Tenant":      "acme",
+		"query":         "page=2&token=REDACTED",
+		"password":      Redacted,
+	}
+	for k, v := range expected {
+		if f[k] != v {
+			t.Fatalf("expected field '%s' to be %v, got %v", k, v, f[k])
+		}
+	}
+}
+
+func TestPathLevels(t *testing.T) {
+	rec := &recorder{}
+	conf := Config{
+		Logger: rec,
+		End:    true,
+		PathLevels: map[string]Level{
+			"/healthz":       OffLevel,
+			"/debug":         DebugLevel,
+			"/debug/verbose": WarnLevel,
+		},
+	}
+	cases := []struct {
+		Path    string
+		Entries int
+		Level   Level
+	}{
+		{"/healthz", 0, 0},
+		{"/debug/x", 1, DebugLevel},
+		{"/debug/verbose/x", 1, WarnLevel},
+		{"/other", 1, InfoLevel},
+	}
+	for _, c := range cases {
+		serveLogged(conf, httptest.NewRequest("GET", c.Path, nil))
+		entries := rec.reset()
+		if len(entries) != c.Entries {
+			t.Fatalf("%s: expected %v entries, got %v", c.Path, c.Entries, len(entries))
+		}
+		if c.Entries > 0 && entries[0].level != c.Level {
+			t.Fatalf("%s: expected level %v, got %v", c.Path, c.Level, entries[0].level)
+		}
+	}
+
+	// Errors on a silenced path are still logged.
+	conf.PathLevels["/fail"] = OffLevel
+	serveLogged(conf, httptest.NewRequest("GET", "/fail", nil))
+	if n := len(rec.reset()); n != 1 {
+		t.Fatalf("expected the error to be logged, got %v entries", n)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	rec := &recorder{}
+	conf := Config{Logger: rec, End: true, SampleLimit: 3}
+	m := New(conf)
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/fail" {
+			return httpware.NewErr("failed", http.StatusInternalServerError)
+		}
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	for i := 0; i < 5; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	}
+	successes, errors := 0, 0
+	for _, e := range rec.reset() {
+		if e.level == ErrorLevel {
+			errors++
+		} else {
+			successes++
+		}
+	}
+	// The limit could reset once if the second ticks over.
+	if successes < 3 || successes > 6 {
+		t.Fatalf("expected about 3 sampled successes, got %v", successes)
+	}
+	if errors != 5 {
+		t.Fatalf("expected all 5 errors to be logged, got %v", errors)
+	}
+
+	s := newSampler(0.5, 0)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if s.sample() {
+			sampled++
+		}
+	}
+	if sampled < 350 || sampled > 650 {
+		t.Fatalf("expected about half to be sampled, got %v of 1000", sampled)
+	}
+}
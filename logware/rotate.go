@@ -0,0 +1,161 @@
+package logware
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RotatingFile is an io.Writer which appends to a file. It can rotate the file
+// itself once it reaches a size limit, and it can reopen the file when an
+// external tool such as logrotate has moved it (usually signalled with
+// SIGHUP, see ReopenOnSignal).
+type RotatingFile struct {
+	path    string
+	maxSize int64
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotatingFile opens (or creates) the file at path for appending. When
+// maxSize is greater than zero, the file is renamed with a timestamp suffix
+// (ie: "access.log.20161231-235959.000") before a write would make it grow
+// beyond maxSize bytes, and a new file is started.
+func OpenRotatingFile(path string, maxSize int64) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write fulfills the io.Writer interface. When the file can not be rotated
+// the write goes to the current file, and rotating is tried again on the next
+// write.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		// A previous rotation or reopen failed to open the file.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and opens a new one. If renaming fails the file at
+// the path is opened again, so that writes can continue.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	if err := os.Rename(f.path, f.rotatedPath()); err != nil {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return f.open()
+}
+
+// rotatedPath returns a name for the rotated file which is not taken yet. A
+// counter is added for rotations within the same millisecond.
+func (f *RotatingFile) rotatedPath() string {
+	base := f.path + "." + time.Now().Format("20060102-150405.000")
+	rotated := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			return rotated
+		}
+		rotated = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+// Rotate renames the current file and starts a new one.
+func (f *RotatingFile) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	return f.rotate()
+}
+
+// Reopen closes the file and opens the file at the path again. If that fails,
+// the next write tries again.
+func (f *RotatingFile) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// ReopenOnSignal reopens the file whenever one of the given signals is
+// received. It defaults to SIGHUP. The returned function stops listening.
+func (f *RotatingFile) ReopenOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		for {
+			select {
+			case <-c:
+				f.Reopen()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,88 @@
+package logware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	f, err := OpenRotatingFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("12345678\n"))
+	// Would exceed the limit.
+	f.Write([]byte("abc\n"))
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 rotated file, got: %v", matches)
+	}
+	if bs, _ := ioutil.ReadFile(matches[0]); string(bs) != "12345678\n" {
+		t.Fatalf("unexpected rotated file contents: %s", bs)
+	}
+	if bs, _ := ioutil.ReadFile(path); string(bs) != "abc\n" {
+		t.Fatalf("unexpected current file contents: %s", bs)
+	}
+
+	// An external tool moves the file away.
+	moved := filepath.Join(dir, "moved.log")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("def\n"))
+	if bs, _ := ioutil.ReadFile(path); string(bs) != "def\n" {
+		t.Fatalf("expected writes to go to the reopened file, got: %s", bs)
+	}
+}
+
+func TestRotatingFileRecovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	f, err := OpenRotatingFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		f.Write([]byte("line\n"))
+		if err := f.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 3 {
+		t.Fatalf("expected 3 rotated files, got: %v", matches)
+	}
+
+	// Renaming fails when the file was removed, writes continue at the path.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Rotate(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := f.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(path); string(bs) != "after\n" {
+		t.Fatalf("expected writes to continue, got: %s", bs)
+	}
+}
@@ -0,0 +1,45 @@
+package logware
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// NewSlog returns a Logger which writes to a slog.Logger. Fields are added as
+// attributes, sorted by key.
+func NewSlog(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+var slogLevels = map[Level]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+}
+
+func (sl slogLogger) Log(level Level, msg string, fields Fields) {
+	lvl, ok := slogLevels[level]
+	if !ok {
+		lvl = slog.LevelInfo
+	}
+	ctx := context.Background()
+	if !sl.l.Enabled(ctx, lvl) {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, k := range keys {
+		attrs[i] = slog.Any(k, fields[k])
+	}
+	sl.l.LogAttrs(ctx, lvl, msg, attrs...)
+}
@@ -0,0 +1,134 @@
+package metricsware
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler which serves the metrics in the Prometheus
+// text exposition format, ie: on "/metrics".
+func (m *Middle) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		bw := bufio.NewWriter(w)
+		m.write(bw)
+		bw.Flush()
+	})
+}
+
+func (m *Middle) write(w *bufio.Writer) {
+	m.mutex.Lock()
+	keys := make([]labels, 0, len(m.series))
+	for l := range m.series {
+		keys = append(keys, l)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.status < b.status
+	})
+	// Copy the values so that requests are not blocked while writing.
+	snapshot := make([]series, len(keys))
+	for i, l := range keys {
+		s := m.series[l]
+		snapshot[i] = series{count: s.count, duration: s.duration.copy(), size: s.size.copy()}
+	}
+	extra := append([]extraMetric(nil), m.extra...)
+	m.mutex.Unlock()
+
+	name := m.name("http_requests_total")
+	writeHeader(w, name, "Total number of HTTP requests.", "counter")
+	for i, l := range keys {
+		writeSample(w, name, l.String(), float64(snapshot[i].count))
+	}
+
+	name = m.name("http_requests_in_flight")
+	writeHeader(w, name, "Number of HTTP requests being served.", "gauge")
+	writeSample(w, name, "", float64(atomic.LoadInt64(&m.inFlight)))
+
+	name = m.name("http_request_duration_seconds")
+	writeHeader(w, name, "Latency of HTTP requests in seconds.", "histogram")
+	for i, l := range keys {
+		snapshot[i].duration.write(w, name, l.String())
+	}
+
+	name = m.name("http_response_size_bytes")
+	writeHeader(w, name, "Size of HTTP response bodies in bytes.", "histogram")
+	for i, l := range keys {
+		snapshot[i].size.write(w, name, l.String())
+	}
+
+	for _, e := range extra {
+		writeHeader(w, e.name, e.help, e.kind)
+		writeSample(w, e.name, "", e.value())
+	}
+}
+
+// String formats the labels for the exposition format.
+func (l labels) String() string {
+	return `method="` + escapeLabel(l.method) + `",route="` + escapeLabel(l.route) + `",status="` + escapeLabel(l.status) + `"`
+}
+
+func (h *histogram) copy() *histogram {
+	c := *h
+	c.counts = append([]uint64(nil), h.counts...)
+	return &c
+}
+
+func (h *histogram) write(w *bufio.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		writeSample(w, name+"_bucket", labels+`,le="`+formatFloat(bound)+`"`, float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", labels+`,le="+Inf"`, float64(h.count))
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(h.count))
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func escapeHelp(v string) string { return helpEscaper.Replace(v) }
@@ -0,0 +1,207 @@
+/*
+Package metricsware provides middleware which records request metrics and
+exposes them in the Prometheus text exposition format. It records the number
+of requests, the requests in flight, and histograms of the latency and
+response size, labelled by method, route template and status class (ie:
+"2xx"). It implements the httpware.Middleware interface for easy composition
+with other middleware.
+*/
+package metricsware
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+var (
+	// DefaultDurationBuckets are the upper bounds of the latency histogram
+	// buckets in seconds.
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// DefaultSizeBuckets are the upper bounds of the response size histogram
+	// buckets in bytes.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+	// Defaults is a reasonable configuration that should work for most cases.
+	Defaults = Config{
+		DurationBuckets: DefaultDurationBuckets,
+		SizeBuckets:     DefaultSizeBuckets,
+	}
+)
+
+// Config is used to initialize a new instance of this middleware.
+type Config struct {
+	// Prefixed to the metric names, ie: "myapp" gives
+	// "myapp_http_requests_total".
+	Namespace string
+	// Defaults to DefaultDurationBuckets.
+	DurationBuckets []float64
+	// Defaults to DefaultSizeBuckets.
+	SizeBuckets []float64
+}
+
+// Middle records request metrics. The metrics are served by Handler.
+type Middle struct {
+	conf     Config
+	inFlight int64
+
+	mutex  sync.Mutex
+	series map[labels]*series
+	extra  []extraMetric
+}
+
+// labels identify a series of request metrics. The route is the template the
+// request was routed with, see routeradapt.AdaptRoute.
+type labels struct {
+	method string
+	route  string
+	status string
+}
+
+type series struct {
+	count    uint64
+	duration *histogram
+	size     *histogram
+}
+
+// extraMetric is a metric which is read from a function when exposed.
+type extraMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func() float64
+}
+
+// New returns a new instance of the middleware.
+func New(conf Config) *Middle {
+	if conf.DurationBuckets == nil {
+		conf.DurationBuckets = DefaultDurationBuckets
+	}
+	if conf.SizeBuckets == nil {
+		conf.SizeBuckets = DefaultSizeBuckets
+	}
+	conf.DurationBuckets = sortedBuckets(conf.DurationBuckets)
+	conf.SizeBuckets = sortedBuckets(conf.SizeBuckets)
+	return &Middle{
+		conf:   conf,
+		series: make(map[labels]*series),
+	}
+}
+
+// RegisterGauge exposes the value returned by f as a gauge, ie: the number of
+// active streams of a streamware.Middle:
+//
+//	m.RegisterGauge("sse_active_streams", "Open event streams.", func() float64 {
+//		return float64(stream.Active())
+//	})
+//
+// The namespace is prefixed to the name. f is called every time the metrics
+// are served, so it must be safe for concurrent use.
+func (m *Middle) RegisterGauge(name, help string, f func() float64) {
+	m.register(name, help, "gauge", f)
+}
+
+// RegisterCounter exposes the value returned by f as a counter, ie: the
+// requests rejected by a limitware.Middle. See RegisterGauge.
+func (m *Middle) RegisterCounter(name, help string, f func() float64) {
+	m.register(name, help, "counter", f)
+}
+
+func (m *Middle) register(name, help, kind string, f func() float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.extra = append(m.extra, extraMetric{name: m.name(name), help: help, kind: kind, value: f})
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt64(&m.inFlight, 1)
+		start := time.Now()
+		sw := httpware.WrapWriter(w)
+		route, _ := ctx.Value(httpware.RouteKey).(string)
+
+		// A panic is recorded as a server error.
+		status := http.StatusInternalServerError
+		defer func() {
+			atomic.AddInt64(&m.inFlight, -1)
+			m.observe(labels{method(r.Method), route, statusClass(status)}, time.Since(start), sw.Written())
+		}()
+
+		err := next.ServeHTTPCtx(ctx, sw.Writer(), r)
+
+		status = sw.Status()
+		if status == 0 {
+			status = httpware.StatusOf(err)
+		}
+		return err
+	})
+}
+
+func (m *Middle) observe(l labels, d time.Duration, size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.series[l]
+	if !ok {
+		s = &series{
+			duration: newHistogram(m.conf.DurationBuckets),
+			size:     newHistogram(m.conf.SizeBuckets),
+		}
+		m.series[l] = s
+	}
+	s.count++
+	s.duration.observe(d.Seconds())
+	s.size.observe(float64(size))
+}
+
+func (m *Middle) name(name string) string {
+	if m.conf.Namespace == "" {
+		return name
+	}
+	return m.conf.Namespace + "_" + name
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return string(rune('0'+status/100)) + "xx"
+}
+
+// method bounds the label values, as clients can send any method.
+func method(m string) string {
+	switch m {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		return m
+	}
+	return "OTHER"
+}
+
+type histogram struct {
+	bounds []float64
+	// Not cumulative, the last element counts the values above all bounds.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return sorted
+}
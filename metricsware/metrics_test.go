@@ -0,0 +1,86 @@
+package metricsware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New(Config{
+		Namespace:       "app",
+		DurationBuckets: []float64{10, 0.5},
+		SizeBuckets:     []float64{5},
+	})
+	m.RegisterGauge("sse_active_streams", "Open event streams.", func() float64 { return 3 })
+	m.RegisterCounter("limit_rejected_total", "Rejected requests.", func() float64 { return 7 })
+
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/missing" {
+			return httpware.NewErr("not found", http.StatusNotFound)
+		}
+		w.Write([]byte("hello world"))
+		return nil
+	})
+	serve := func(method, path string) {
+		ctx := context.WithValue(context.Background(), httpware.RouteKey, "/items/:id")
+		h.ServeHTTPCtx(ctx, httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	serve("GET", "/items/1")
+	serve("GET", "/items/2")
+	serve("GET", "/missing")
+	serve("BREW", "/items/1")
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Fatalf("expected content type %q, got %q", ContentType, got)
+	}
+	body := rec.Body.String()
+	expected := []string{
+		"# HELP app_http_requests_total Total number of HTTP requests.\n# TYPE app_http_requests_total counter\n",
+		`app_http_requests_total{method="GET",route="/items/:id",status="2xx"} 2` + "\n",
+		`app_http_requests_total{method="GET",route="/items/:id",status="4xx"} 1` + "\n",
+		`app_http_requests_total{method="OTHER",route="/items/:id",status="2xx"} 1` + "\n",
+		"# TYPE app_http_requests_in_flight gauge\napp_http_requests_in_flight 0\n",
+		"# TYPE app_http_request_duration_seconds histogram\n",
+		`app_http_request_duration_seconds_bucket{method="GET",route="/items/:id",status="2xx",le="0.5"} 2` + "\n" +
+			`app_http_request_duration_seconds_bucket{method="GET",route="/items/:id",status="2xx",le="10"} 2` + "\n" +
+			`app_http_request_duration_seconds_bucket{method="GET",route="/items/:id",status="2xx",le="+Inf"} 2` + "\n",
+		`app_http_request_duration_seconds_count{method="GET",route="/items/:id",status="2xx"} 2` + "\n",
+		`app_http_response_size_bytes_bucket{method="GET",route="/items/:id",status="2xx",le="5"} 0` + "\n" +
+			`app_http_response_size_bytes_bucket{method="GET",route="/items/:id",status="2xx",le="+Inf"} 2` + "\n" +
+			`app_http_response_size_bytes_sum{method="GET",route="/items/:id",status="2xx"} 22` + "\n",
+		"# TYPE app_sse_active_streams gauge\napp_sse_active_streams 3\n",
+		"# TYPE app_limit_rejected_total counter\napp_limit_rejected_total 7\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Fatalf("expected the metrics to contain:\n%s\ngot:\n%s", e, body)
+		}
+	}
+}
+
+func TestPanicIsServerError(t *testing.T) {
+	m := New(Defaults)
+	h := httpware.Compose(httpware.DefaultErrHandler, m).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		panic("boom")
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if e := `http_requests_total{method="GET",route="",status="5xx"} 1`; !strings.Contains(rec.Body.String(), e) {
+		t.Fatalf("expected the metrics to contain %s, got:\n%s", e, rec.Body.String())
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("unexpected escaping: %s", got)
+	}
+}
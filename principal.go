@@ -0,0 +1,42 @@
+package httpware
+
+import "context"
+
+// Principal is the authenticated client of a request. Authentication
+// middleware such as tokenware store it in the context, authorization
+// middleware read it with PrincipalFromCtx.
+type Principal struct {
+	// The ID of the user or client, ie: the "sub" claim of a JWT.
+	Subject string
+	// The permissions granted to the client, ie: "orders:write".
+	Scopes []string
+	Roles  []string
+	// Further attributes, ie: the claims of a JWT.
+	Claims map[string]interface{}
+}
+
+// HasScope reports whether the principal was granted the scope.
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// HasRole reports whether the principal has the role.
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalFromCtx retrieves the authenticated client, or nil for anonymous
+// requests.
+func PrincipalFromCtx(ctx context.Context) *Principal {
+	p, _ := ctx.Value(PrincipalKey).(*Principal)
+	return p
+}
@@ -9,4 +9,10 @@ const (
 	ResponseContentTypeKey
 	PageKey
 	SenderKey
+	RouteKey
+	LoggerKey
+	RequestIDKey
+	SpanKey
+	PrincipalKey
+	APIKeyKey
 )
@@ -0,0 +1,60 @@
+package reqidware
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerators(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Generate func() string
+		Pattern  *regexp.Regexp
+	}{
+		{"UUIDv4", NewUUIDv4, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{"UUIDv7", NewUUIDv7, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{"ULID", NewULID, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	}
+	for _, c := range cases {
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			id := c.Generate()
+			if !c.Pattern.MatchString(id) {
+				t.Fatalf("%s: malformed ID %q", c.Name, id)
+			}
+			if seen[id] {
+				t.Fatalf("%s: duplicate ID %q", c.Name, id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestTimeOrdered(t *testing.T) {
+	for _, generate := range []func() string{NewUUIDv7, NewULID} {
+		var ids []string
+		for i := 0; i < 3; i++ {
+			ids = append(ids, generate())
+			time.Sleep(2 * time.Millisecond)
+		}
+		if !sort.StringsAreSorted(ids) {
+			t.Fatalf("expected IDs to sort by creation time, got %v", ids)
+		}
+	}
+}
+
+func TestULIDTimestamp(t *testing.T) {
+	now := time.Now()
+	id := NewULID()
+	// The first 10 characters hold the 48 bit timestamp.
+	var ms uint64
+	for _, c := range id[:10] {
+		ms = ms<<5 | uint64(strings.IndexByte(crockford, byte(c)))
+	}
+	if diff := int64(ms) - now.UnixNano()/int64(time.Millisecond); diff < 0 || diff > 1000 {
+		t.Fatalf("expected the ULID to start with the current time, got %v ms off", diff)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Package reqidware provides middleware which gives every request an ID. The ID
+is read from a request header, or generated when the header is missing or
+invalid. It is stored in the context and echoed in the response header, where
+httpware.ErrHandler picks it up for error bodies. It implements the
+httpware.Middleware interface for easy composition with other middleware.
+Compose it before logware so that log entries include the ID.
+*/
+package reqidware
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/nstogner/httpware"
+)
+
+var (
+	// Defaults is a reasonable configuration that should work for most cases.
+	Defaults = Config{
+		Header:    "X-Request-ID",
+		Generate:  NewUUIDv4,
+		Pattern:   regexp.MustCompile(`^[A-Za-z0-9._~:/+=-]+$`),
+		MaxLength: 128,
+	}
+
+	// AnyID is a Config.Pattern which accepts any incoming ID.
+	AnyID = regexp.MustCompile(``)
+)
+
+// Config is used to initialize a new instance of this middleware.
+type Config struct {
+	// The header the ID is read from and echoed in. Defaults to
+	// "X-Request-ID".
+	Header string
+	// Generates new IDs, ie: NewUUIDv4, NewUUIDv7 or NewULID. Defaults to
+	// NewUUIDv4.
+	Generate func() string
+	// Incoming IDs which do not match are replaced by a generated ID.
+	// Defaults to the pattern of Defaults, use AnyID to accept any ID.
+	Pattern *regexp.Regexp
+	// Incoming IDs which are longer are replaced by a generated ID. Defaults
+	// to 128, a negative value disables the check.
+	MaxLength int
+	// Ignore incoming IDs and always generate one, ie: for public facing
+	// services.
+	IgnoreIncoming bool
+}
+
+// RequestIDFromCtx returns the ID of the request, or an empty string if the
+// request is not served through the middleware.
+func RequestIDFromCtx(ctx context.Context) string {
+	id, _ := ctx.Value(httpware.RequestIDKey).(string)
+	return id
+}
+
+// Middle reads or generates request IDs.
+type Middle struct {
+	conf Config
+}
+
+// New returns a new instance of the middleware.
+func New(conf Config) *Middle {
+	if conf.Header == "" {
+		conf.Header = Defaults.Header
+	}
+	if conf.Generate == nil {
+		conf.Generate = Defaults.Generate
+	}
+	if conf.Pattern == nil {
+		conf.Pattern = Defaults.Pattern
+	}
+	if conf.MaxLength == 0 {
+		conf.MaxLength = Defaults.MaxLength
+	}
+	return &Middle{
+		conf: conf,
+	}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		id := r.Header.Get(m.conf.Header)
+		if m.conf.IgnoreIncoming || !m.valid(id) {
+			id = m.conf.Generate()
+		}
+		w.Header().Set(m.conf.Header, id)
+		return next.ServeHTTPCtx(context.WithValue(ctx, httpware.RequestIDKey, id), w, r)
+	})
+}
+
+func (m *Middle) valid(id string) bool {
+	if id == "" {
+		return false
+	}
+	if m.conf.MaxLength > 0 && len(id) > m.conf.MaxLength {
+		return false
+	}
+	return m.conf.Pattern.MatchString(id)
+}
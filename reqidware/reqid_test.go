@@ -0,0 +1,110 @@
+package reqidware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	m := httpware.Compose(httpware.DefaultErrHandler, New(Defaults))
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		got = RequestIDFromCtx(ctx)
+		if r.URL.Path == "/fail" {
+			return httpware.NewErr("nope", http.StatusBadRequest)
+		}
+		return nil
+	})
+
+	cases := []struct {
+		Name     string
+		Incoming string
+		Keep     bool
+	}{
+		{"missing", "", false},
+		{"valid", "abc-123", true},
+		{"invalid characters", "abc 123", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.Incoming != "" {
+			r.Header.Set("X-Request-ID", c.Incoming)
+		}
+		h.ServeHTTP(rec, r)
+		if c.Keep && got != c.Incoming {
+			t.Fatalf("%s: expected ID %q to be kept, got %q", c.Name, c.Incoming, got)
+		}
+		if !c.Keep && (got == c.Incoming || len(got) != 36) {
+			t.Fatalf("%s: expected a generated ID, got %q", c.Name, got)
+		}
+		if echoed := rec.Header().Get("X-Request-ID"); echoed != got {
+			t.Fatalf("%s: expected the ID %q to be echoed, got %q", c.Name, got, echoed)
+		}
+	}
+
+	// The ID is included in error bodies.
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/fail", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("X-Request-ID", "req-1")
+	h.ServeHTTP(rec, r)
+	var body struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != "req-1" {
+		t.Fatalf("expected requestId 'req-1' in the error body, got %q", body.RequestID)
+	}
+}
+
+func TestCustomHeader(t *testing.T) {
+	m := New(Config{Header: "X-Correlation-ID", Generate: NewULID, IgnoreIncoming: true})
+	h := httpware.Compose(httpware.NewErrHandler(httpware.ErrHandlerConfig{RequestIDHeader: "X-Correlation-ID"}), m).
+		ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Correlation-ID", "incoming")
+	h.ServeHTTP(rec, r)
+	if id := rec.Header().Get("X-Correlation-ID"); id == "incoming" || len(id) != 26 {
+		t.Fatalf("expected a generated ULID, got %q", id)
+	}
+}
+
+func TestValidationDefaults(t *testing.T) {
+	serve := func(conf Config, incoming string) string {
+		var got string
+		h := httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			got = RequestIDFromCtx(ctx)
+			return nil
+		})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-ID", incoming)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+	long := strings.Repeat("a", 129)
+	if got := serve(Config{}, "abc 123"); got == "abc 123" {
+		t.Fatal("expected the zero config to validate the pattern")
+	}
+	if got := serve(Config{}, long); got == long {
+		t.Fatal("expected the zero config to validate the length")
+	}
+	if got := serve(Config{Pattern: AnyID, MaxLength: -1}, "abc 123"); got != "abc 123" {
+		t.Fatalf("expected AnyID to accept any ID, got %q", got)
+	}
+	if got := serve(Config{MaxLength: -1}, long); got != long {
+		t.Fatalf("expected a negative MaxLength to disable the check, got %q", got)
+	}
+}
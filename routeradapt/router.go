@@ -27,6 +27,22 @@ func AdaptFunc(hf httpware.HandlerFunc) httprouter.Handle {
 	}
 }
 
+// AdaptRoute is like Adapt, but it also stores the route the handler is
+// registered for (ie: "/users/:id") in the context. Middleware such as logware
+// use it to group requests by route instead of by path.
+func AdaptRoute(route string, h httpware.Handler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := context.WithValue(context.Background(), httpware.RouteKey, route)
+		h.ServeHTTPCtx(context.WithValue(ctx, httpware.RouterParamsKey, ps), w, r)
+	}
+}
+
+// RouteFromCtx retrieves the route stored by AdaptRoute, or an empty string.
+func RouteFromCtx(ctx context.Context) string {
+	route, _ := ctx.Value(httpware.RouteKey).(string)
+	return route
+}
+
 // ParamsFromCtx retrieves the httprouter.Params that are set by httprouter.
 func ParamsFromCtx(ctx context.Context) httprouter.Params {
 	return ctx.Value(httpware.RouterParamsKey).(httprouter.Params)
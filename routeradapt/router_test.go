@@ -36,3 +36,26 @@ func TestAdapt(t *testing.T) {
 		t.Fatalf("expected status code %v, got %v", http.StatusNoContent, resp.StatusCode)
 	}
 }
+
+func TestAdaptRoute(t *testing.T) {
+	r := httprouter.New()
+	r.GET("/test/:id", AdaptRoute("/test/:id", httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if route := RouteFromCtx(ctx); route != "/test/:id" {
+			t.Fatalf("expected route to equal '/test/:id', got '%s'", route)
+		}
+		if ParamsFromCtx(ctx).ByName("id") != "abc" {
+			t.Fatal("expected id param to equal 'abc'")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})))
+	s := httptest.NewServer(r)
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/test/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status code %v, got %v", http.StatusNoContent, resp.StatusCode)
+	}
+}
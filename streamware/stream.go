@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/nstogner/httpware"
 )
@@ -22,6 +23,7 @@ type Config struct {
 
 // Middle is middleware that enables Server Sent Events.
 type Middle struct {
+	active int64
 }
 
 // New creates a new Middle instance.
@@ -71,6 +73,13 @@ func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
 			CloseNotify: w.(http.CloseNotifier).CloseNotify(),
 		}
 
+		atomic.AddInt64(&m.active, 1)
+		defer atomic.AddInt64(&m.active, -1)
 		return next.ServeHTTPCtx(context.WithValue(ctx, httpware.SenderKey, sender), w, r)
 	})
 }
+
+// Active returns the number of open streams.
+func (m *Middle) Active() int64 {
+	return atomic.LoadInt64(&m.active)
+}
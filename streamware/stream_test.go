@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/nstogner/httpware"
 )
@@ -47,3 +48,34 @@ func TestStreaming(t *testing.T) {
 		i++
 	}
 }
+
+func TestActive(t *testing.T) {
+	stream := New(Defaults)
+	m := httpware.Compose(httpware.DefaultErrHandler, stream)
+	started, release := make(chan struct{}), make(chan struct{})
+	s := httptest.NewServer(m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		sender := SenderFromCtx(ctx)
+		sender.Send("hello")
+		started <- struct{}{}
+		<-release
+		return nil
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if got := stream.Active(); got != 1 {
+		t.Fatalf("expected 1 active stream, got %v", got)
+	}
+	close(release)
+	resp.Body.Close()
+	for i := 0; stream.Active() != 0; i++ {
+		if i == 100 {
+			t.Fatal("expected the stream to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
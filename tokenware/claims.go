@@ -0,0 +1,189 @@
+package tokenware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// The codes of the errors returned for rejected tokens, in the "code" field.
+const (
+	CodeMissingToken     = "missing_token"
+	CodeInvalidToken     = "invalid_token"
+	CodeTokenExpired     = "token_expired"
+	CodeTokenNotYetValid = "token_not_yet_valid"
+	CodeInvalidIssuer    = "invalid_issuer"
+	CodeInvalidAudience  = "invalid_audience"
+	CodeMissingClaim     = "missing_claim"
+)
+
+// claimsError is a failed claim validation.
+type claimsError struct {
+	code   string
+	reason string
+}
+
+func (e claimsError) Error() string {
+	return e.reason
+}
+
+// decodePayload returns the claims of a token as a map, whatever the claims
+// type of the middleware is. Numbers are json.Number values.
+func decodePayload(raw string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, claimsError{CodeInvalidToken, "malformed token"}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, claimsError{CodeInvalidToken, "malformed payload"}
+	}
+	var claims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, claimsError{CodeInvalidToken, "malformed payload"}
+	}
+	return claims, nil
+}
+
+// validateClaims checks the registered claims.
+func (m *Middle) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	leeway := m.conf.Leeway
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(leeway)) {
+		return claimsError{CodeTokenExpired, "token expired at " + exp.Format(time.RFC3339)}
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(leeway).Before(nbf) {
+		return claimsError{CodeTokenNotYetValid, "token is not valid before " + nbf.Format(time.RFC3339)}
+	}
+	if iat, ok, err := numericDate(claims, "iat"); err != nil {
+		return err
+	} else if ok && now.Add(leeway).Before(iat) {
+		return claimsError{CodeTokenNotYetValid, "token was issued in the future at " + iat.Format(time.RFC3339)}
+	}
+
+	if m.conf.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != m.conf.Issuer {
+			return claimsError{CodeInvalidIssuer, "unexpected issuer: " + iss}
+		}
+	}
+	if len(m.conf.Audience) > 0 && !matchAudience(claims["aud"], m.conf.Audience) {
+		return claimsError{CodeInvalidAudience, "the audience does not include an accepted value"}
+	}
+	for _, name := range m.conf.RequiredClaims {
+		if v, ok := claims[name]; !ok || v == nil {
+			return claimsError{CodeMissingClaim, "missing claim: " + name}
+		}
+	}
+	return nil
+}
+
+// checkRevoked checks the token against Config.Revocation. Tokens are
+// rejected when the check fails.
+func (m *Middle) checkRevoked(claims map[string]interface{}) error {
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	iat, _, _ := numericDate(claims, "iat")
+	revoked, err := m.conf.Revocation.Revoked(jti, sub, iat)
+	if err != nil {
+		return fmt.Errorf("tokenware: checking revocation: %v", err)
+	}
+	if revoked {
+		return claimsError{CodeTokenRevoked, "token revoked"}
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim (seconds since the epoch).
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, claimsError{CodeInvalidToken, "claim " + name + " is not a number"}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, claimsError{CodeInvalidToken, "claim " + name + " is not a number"}
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true, nil
+}
+
+// matchAudience reports whether the "aud" claim, a string or an array of
+// strings, contains one of the accepted audiences.
+func matchAudience(aud interface{}, accepted []string) bool {
+	var auds []string
+	switch aud := aud.(type) {
+	case string:
+		auds = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+	for _, a := range auds {
+		for _, acc := range accepted {
+			if a == acc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// principal describes the client of a token. The scopes are read from the
+// "scope" claim (space separated, RFC 8693) or the "scp" claim, the roles
+// from the "roles" claim.
+func principal(claims map[string]interface{}) *httpware.Principal {
+	p := &httpware.Principal{Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else {
+		p.Scopes = stringList(claims["scp"])
+	}
+	p.Roles = stringList(claims["roles"])
+	return p
+}
+
+// stringList reads a claim which is a string or an array of strings.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var list []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// errorCode returns the code of a failed token validation.
+func errorCode(err error) string {
+	var ce claimsError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return CodeInvalidToken
+}
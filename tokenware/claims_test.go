@@ -0,0 +1,159 @@
+package tokenware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+)
+
+func TestClaimValidation(t *testing.T) {
+	secret := []byte("shh")
+	conf := Config{
+		Algorithms:     []string{"HS256"},
+		Secret:         secret,
+		Issuer:         "https://idp.example.com",
+		Audience:       []string{"api", "admin"},
+		RequiredClaims: []string{"sub"},
+		Leeway:         time.Minute,
+	}
+	h := httpware.Compose(httpware.DefaultErrHandler, New(conf)).ThenFunc(okHandler)
+	now := time.Now().Unix()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://idp.example.com", "aud": "api", "sub": "user-1", "exp": now + 60}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		c := valid()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+
+	cases := []struct {
+		Name   string
+		Claims jwt.MapClaims
+		Code   string
+	}{
+		{"valid", valid(), ""},
+		{"audience list", with("aud", []string{"other", "admin"}), ""},
+		{"expired within leeway", with("exp", now-30), ""},
+		{"expired", with("exp", now-120), CodeTokenExpired},
+		{"not yet valid within leeway", with("nbf", now+30), ""},
+		{"not yet valid", with("nbf", now+120), CodeTokenNotYetValid},
+		{"issuer", with("iss", "https://evil.example.com"), CodeInvalidIssuer},
+		{"audience", with("aud", []string{"other"}), CodeInvalidAudience},
+		{"missing audience", with("aud", nil), CodeInvalidAudience},
+		{"required claim", with("sub", nil), CodeMissingClaim},
+		{"malformed exp", with("exp", "tomorrow"), CodeInvalidToken},
+	}
+	for _, c := range cases {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c.Claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Accept", "application/json")
+		h.ServeHTTP(rec, r)
+		if c.Code == "" {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected status code %v, got %v: %s", c.Name, http.StatusOK, rec.Code, rec.Body)
+			}
+			continue
+		}
+		var body struct {
+			Fields struct {
+				Code string `json:"code"`
+			} `json:"fields"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnauthorized || body.Fields.Code != c.Code {
+			t.Fatalf("%s: expected 401 with code %s, got %v with %s", c.Name, c.Code, rec.Code, body.Fields.Code)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); got != `Bearer error="invalid_token"` {
+			t.Fatalf("%s: unexpected WWW-Authenticate header: %s", c.Name, got)
+		}
+	}
+
+	// Requests without a token.
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	h.ServeHTTP(rec, r)
+	if rec.Body.String() != `{"message":"invalid token","fields":{"code":"missing_token"}}`+"\n" || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("unexpected response without a token: %s", rec.Body)
+	}
+}
+
+type customClaims struct {
+	jwt.StandardClaims
+	Tenant string `json:"tenant"`
+}
+
+func TestTypedClaims(t *testing.T) {
+	secret := []byte("shh")
+	var got *customClaims
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+		NewClaims:  func() jwt.Claims { return &customClaims{} },
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		got = ClaimsFromCtx(ctx).(*customClaims)
+		return nil
+	})
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "user-1"},
+		Tenant:         "acme",
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(rec, r)
+	if got == nil || got.Subject != "user-1" || got.Tenant != "acme" {
+		t.Fatalf("expected typed claims, got %+v", got)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	secret := []byte("shh")
+	var got *httpware.Principal
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		got = httpware.PrincipalFromCtx(ctx)
+		return nil
+	})
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "user-1",
+		"scope": "orders:read orders:write",
+		"roles": []string{"admin"},
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(rec, r)
+	if got == nil {
+		t.Fatal("expected a principal")
+	}
+	if got.Subject != "user-1" || !got.HasScope("orders:write") || !got.HasRole("admin") || got.HasScope("orders") {
+		t.Fatalf("unexpected principal: %+v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package tokenware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go/request"
+)
+
+// An Extractor finds the token in a request. It returns
+// request.ErrNoTokenInRequest if there is none.
+type Extractor = request.Extractor
+
+// BearerToken extracts the token from an 'Authorization: Bearer' header. The
+// scheme is matched case-insensitively, other schemes such as Basic are not
+// tokens. Unlike request.AuthorizationHeaderExtractor it does not return
+// the values of other schemes as they are.
+var BearerToken Extractor = ExtractorFunc(func(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", request.ErrNoTokenInRequest
+	}
+	return auth[7:], nil
+})
+
+// FromCookie extracts the token from the value of the named cookie, ie: for
+// browser clients.
+func FromCookie(name string) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return "", request.ErrNoTokenInRequest
+		}
+		return c.Value, nil
+	})
+}
+
+// FromQuery extracts the token from the named query parameter, ie: for
+// EventSource clients of streamware which can not set headers. Unlike
+// request.ArgumentExtractor it does not read the request body.
+func FromQuery(name string) Extractor {
+	return ExtractorFunc(func(r *http.Request) (string, error) {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v, nil
+		}
+		return "", request.ErrNoTokenInRequest
+	})
+}
+
+// FromHeader extracts the token from the value of the named header.
+func FromHeader(name string) Extractor {
+	return request.HeaderExtractor{name}
+}
+
+// ExtractorFunc is an adapter to allow the use of ordinary functions as an
+// Extractor.
+type ExtractorFunc func(*http.Request) (string, error)
+
+// ExtractToken calls f(r).
+func (f ExtractorFunc) ExtractToken(r *http.Request) (string, error) {
+	return f(r)
+}
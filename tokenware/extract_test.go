@@ -0,0 +1,90 @@
+package tokenware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+)
+
+func TestExtractors(t *testing.T) {
+	secret := []byte("shh")
+	token := signed(t, jwt.SigningMethodHS256, "", secret)
+	var found bool
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+		Extractors: []Extractor{
+			BearerToken,
+			FromCookie("session"),
+			FromQuery("access_token"),
+			FromHeader("X-Token"),
+			ExtractorFunc(func(r *http.Request) (string, error) {
+				return r.FormValue("custom"), nil
+			}),
+		},
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		found = TokenFromCtx(ctx) != nil
+		return nil
+	})
+
+	cases := []struct {
+		Name    string
+		Prepare func(r *http.Request)
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }},
+		{"bearer lowercase", func(r *http.Request) { r.Header.Set("Authorization", "bearer "+token) }},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: token}) }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "access_token=" + token }},
+		{"header", func(r *http.Request) { r.Header.Set("X-Token", token) }},
+		{"func", func(r *http.Request) { r.URL.RawQuery = "custom=" + token }},
+	}
+	for _, c := range cases {
+		found = false
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		c.Prepare(r)
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK || !found {
+			t.Fatalf("%s: expected the token to be found, got status code %v", c.Name, rec.Code)
+		}
+	}
+}
+
+func TestOptional(t *testing.T) {
+	secret := []byte("shh")
+	var anonymous bool
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+		Optional:   true,
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		anonymous = TokenFromCtx(ctx) == nil && ClaimsFromCtx(ctx) == nil
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || !anonymous {
+		t.Fatalf("expected an anonymous request to pass, got status code %v", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || !anonymous {
+		t.Fatalf("expected a request with Basic credentials to pass as anonymous, got status code %v", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signed(t, jwt.SigningMethodHS256, "", []byte("wrong")))
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an invalid token to be rejected, got status code %v", rec.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package tokenware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/request"
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// The maximum size of a response of the introspection endpoint.
+const maxIntrospectionSize = 1 << 20
+
+// IntrospectionConfig is used to initialize a new instance of Introspection.
+type IntrospectionConfig struct {
+	// The introspection endpoint of the authorization server. Required.
+	URL string
+	// The credentials of this service at the authorization server, sent with
+	// HTTP Basic authentication.
+	ClientID     string
+	ClientSecret string
+	// Where the token is looked for, see Config.Extractors. Defaults to
+	// BearerToken.
+	Extractors []Extractor
+	// How long the authorization server is waited for. Requests are rejected
+	// when it does not answer in time. Defaults to 5 seconds.
+	Timeout time.Duration
+	// Active tokens are cached until they expire ("exp"), but for no longer
+	// than CacheTTL, so that revoked tokens are eventually rejected.
+	// Defaults to 5 minutes, a negative value disables the cache.
+	CacheTTL time.Duration
+	// The maximum number of cached tokens. Defaults to 10000.
+	CacheSize int
+	// Defaults to an http.Client without a timeout, see Timeout.
+	Client *http.Client
+}
+
+// Introspection is middleware which validates opaque tokens by calling the
+// introspection endpoint of the authorization server (RFC 7662). It will
+// return an 'Unauthorized' response if the token is missing or not active,
+// and a 'Service Unavailable' response if the authorization server can not
+// be reached: it fails closed. The client of an active token is stored in the
+// context as an httpware.Principal, with the "sub" field (or "client_id" for
+// tokens issued to clients) as the subject, the scopes of the "scope" field
+// and the whole response as the claims.
+type Introspection struct {
+	conf      IntrospectionConfig
+	extractor request.Extractor
+
+	mutex sync.Mutex
+	cache map[string]introspected
+	now   func() time.Time
+}
+
+type introspected struct {
+	principal *httpware.Principal
+	expires   time.Time
+}
+
+// NewIntrospection returns a new instance of Introspection. It panics if
+// IntrospectionConfig.URL is empty.
+func NewIntrospection(conf IntrospectionConfig) *Introspection {
+	if conf.URL == "" {
+		panic("tokenware: IntrospectionConfig.URL is required")
+	}
+	if len(conf.Extractors) == 0 {
+		conf.Extractors = []Extractor{BearerToken}
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	if conf.CacheTTL == 0 {
+		conf.CacheTTL = 5 * time.Minute
+	}
+	if conf.CacheSize == 0 {
+		conf.CacheSize = 10000
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{}
+	}
+	return &Introspection{
+		conf:      conf,
+		extractor: request.MultiExtractor(conf.Extractors),
+		cache:     make(map[string]introspected),
+		now:       time.Now,
+	}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (i *Introspection) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		newCtx, err := i.Authenticate(ctx, r)
+		if err == httpware.ErrNoCredentials {
+			w.Header().Set("WWW-Authenticate", i.Challenge(err))
+			return httpware.NewErr("invalid token", http.StatusUnauthorized).WithField("code", CodeMissingToken)
+		}
+		if httpware.StatusOf(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", i.Challenge(err))
+		}
+		if err != nil {
+			return err
+		}
+		return next.ServeHTTPCtx(newCtx, w, r)
+	})
+}
+
+// Authenticate fulfills the httpware.Authenticator interface.
+func (i *Introspection) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	token, err := i.extractor.ExtractToken(r)
+	if err != nil || token == "" {
+		return ctx, httpware.ErrNoCredentials
+	}
+	key := hashToken(token)
+	p, ok := i.cached(key)
+	if !ok {
+		var expires time.Time
+		p, expires, err = i.introspect(ctx, token)
+		if err != nil {
+			logware.LoggerFromCtx(ctx).AddField("tokenError", err.Error())
+			return ctx, httpware.NewErr("token introspection failed", http.StatusServiceUnavailable)
+		}
+		if p == nil {
+			logware.LoggerFromCtx(ctx).AddField("tokenError", "token is not active")
+			return ctx, httpware.NewErr("invalid token", http.StatusUnauthorized).WithField("code", CodeInvalidToken)
+		}
+		i.store(key, p, expires)
+	}
+	logware.LoggerFromCtx(ctx).AddField("principal", p.Subject)
+	return context.WithValue(ctx, httpware.PrincipalKey, p), nil
+}
+
+// Challenge fulfills the httpware.Authenticator interface.
+func (i *Introspection) Challenge(err error) string {
+	if e, ok := err.(httpware.Err); ok && e.StatusCode == http.StatusUnauthorized {
+		return `Bearer error="invalid_token"`
+	}
+	return "Bearer"
+}
+
+// introspect asks the authorization server about the token. It returns a nil
+// principal for tokens which are not active.
+func (i *Introspection) introspect(ctx context.Context, token string) (*httpware.Principal, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.conf.Timeout)
+	defer cancel()
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", i.conf.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.conf.ClientID != "" {
+		// The credentials are form encoded first (RFC 6749, section 2.3.1).
+		req.SetBasicAuth(url.QueryEscape(i.conf.ClientID), url.QueryEscape(i.conf.ClientSecret))
+	}
+	resp, err := i.conf.Client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("tokenware: introspection endpoint responded with status %v", resp.StatusCode)
+	}
+	var fields map[string]interface{}
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxIntrospectionSize))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, time.Time{}, fmt.Errorf("tokenware: decoding introspection response: %v", err)
+	}
+
+	if active, _ := fields["active"].(bool); !active {
+		return nil, time.Time{}, nil
+	}
+	exp, hasExp, err := numericDate(fields, "exp")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if hasExp && !i.now().Before(exp) {
+		return nil, time.Time{}, nil
+	}
+	p := principal(fields)
+	if p.Subject == "" {
+		p.Subject, _ = fields["client_id"].(string)
+	}
+	return p, exp, nil
+}
+
+// cached returns the principal of a cached active token.
+func (i *Introspection) cached(key string) (*httpware.Principal, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	e, ok := i.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !i.now().Before(e.expires) {
+		delete(i.cache, key)
+		return nil, false
+	}
+	return e.principal, true
+}
+
+// store caches an active token until exp, or for CacheTTL at most. Expired
+// entries are removed when the cache is full, and all of them if it is still
+// full after.
+func (i *Introspection) store(key string, p *httpware.Principal, exp time.Time) {
+	if i.conf.CacheTTL < 0 {
+		return
+	}
+	now := i.now()
+	expires := now.Add(i.conf.CacheTTL)
+	if !exp.IsZero() && exp.Before(expires) {
+		expires = exp
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if len(i.cache) >= i.conf.CacheSize {
+		for k, e := range i.cache {
+			if !now.Before(e.expires) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= i.conf.CacheSize {
+			i.cache = make(map[string]introspected)
+		}
+	}
+	i.cache[key] = introspected{principal: p, expires: expires}
+}
@@ -0,0 +1,145 @@
+package tokenware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestIntrospection(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	responses := map[string]map[string]interface{}{
+		"user-token":   {"active": true, "sub": "alice", "scope": "orders:read orders:write", "client_id": "web", "exp": exp},
+		"client-token": {"active": true, "scope": "reports:read", "client_id": "batch", "exp": exp},
+		"expired":      {"active": true, "sub": "alice", "exp": time.Now().Add(-time.Minute).Unix()},
+		"revoked":      {"active": false},
+	}
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "api" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp, ok := responses[r.PostFormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	var p *httpware.Principal
+	h := httpware.Compose(httpware.DefaultErrHandler, NewIntrospection(IntrospectionConfig{
+		URL:          server.URL,
+		ClientID:     "api",
+		ClientSecret: "s3cret",
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p = httpware.PrincipalFromCtx(ctx)
+		return nil
+	})
+
+	cases := []struct {
+		Name    string
+		Token   string
+		Status  int
+		Subject string
+		Scope   string
+	}{
+		{"user", "user-token", http.StatusOK, "alice", "orders:write"},
+		{"client", "client-token", http.StatusOK, "batch", "reports:read"},
+		{"expired", "expired", http.StatusUnauthorized, "", ""},
+		{"inactive", "revoked", http.StatusUnauthorized, "", ""},
+		{"unknown", "unknown", http.StatusUnauthorized, "", ""},
+	}
+	for _, c := range cases {
+		p = nil
+		if code := authorized(h, c.Token); code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, code)
+		}
+		if c.Status == http.StatusOK && (p == nil || p.Subject != c.Subject || !p.HasScope(c.Scope)) {
+			t.Fatalf("%s: unexpected principal: %+v", c.Name, p)
+		}
+	}
+
+	before := atomic.LoadInt32(&calls)
+	authorized(h, "user-token")
+	authorized(h, "revoked")
+	if n := atomic.LoadInt32(&calls) - before; n != 1 {
+		t.Fatalf("expected only the inactive token to be introspected again, got %v calls", n)
+	}
+}
+
+func TestIntrospectionCacheExpiry(t *testing.T) {
+	now := time.Now()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "alice", "exp": now.Add(time.Minute).Unix()})
+	}))
+	defer server.Close()
+	in := NewIntrospection(IntrospectionConfig{URL: server.URL})
+	h := httpware.Compose(httpware.DefaultErrHandler, in).ThenFunc(okHandler)
+
+	authorized(h, "token")
+	authorized(h, "token")
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 call, got %v", n)
+	}
+	// The cache entry ends at the token's exp, before CacheTTL.
+	in.now = func() time.Time { return now.Add(2 * time.Minute) }
+	authorized(h, "token")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected the token to be introspected again after exp, got %v calls", n)
+	}
+}
+
+func TestIntrospectionFailsClosed(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	h := httpware.Compose(httpware.DefaultErrHandler, NewIntrospection(IntrospectionConfig{
+		URL:     server.URL,
+		Timeout: 50 * time.Millisecond,
+	})).ThenFunc(okHandler)
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %v, got %v", http.StatusServiceUnavailable, rec.Code)
+	}
+	if c := rec.Header().Get("WWW-Authenticate"); c != "" {
+		t.Fatalf("expected no challenge, got %q", c)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	h = httpware.Compose(httpware.DefaultErrHandler, NewIntrospection(IntrospectionConfig{URL: broken.URL})).ThenFunc(okHandler)
+	if code := authorized(h, "token"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %v, got %v", http.StatusServiceUnavailable, code)
+	}
+
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active": true, "sub": "`))
+		w.Write(bytes.Repeat([]byte("a"), maxIntrospectionSize))
+		w.Write([]byte(`"}`))
+	}))
+	defer large.Close()
+	h = httpware.Compose(httpware.DefaultErrHandler, NewIntrospection(IntrospectionConfig{URL: large.URL})).ThenFunc(okHandler)
+	if code := authorized(h, "token"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %v for a response which is too large, got %v", http.StatusServiceUnavailable, code)
+	}
+}
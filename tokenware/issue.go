@@ -0,0 +1,278 @@
+package tokenware
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
+)
+
+// CodeInvalidGrant is the "code" of errors for refresh tokens which are
+// unknown, expired, revoked or reused.
+const CodeInvalidGrant = "invalid_grant"
+
+// IssuerConfig is used to initialize a new instance of Issuer.
+type IssuerConfig struct {
+	// The signing algorithm, ie: jwt.SigningMethodRS256. Required.
+	Method jwt.SigningMethod
+	// The signing key: an *rsa.PrivateKey, *ecdsa.PrivateKey or []byte
+	// (HMAC secret), matching Method.
+	Key interface{}
+	// Sent as the "kid" header, so verifiers can pick the key from a JWKS.
+	KeyID string
+	// The "iss" claim.
+	Issuer string
+	// The "aud" claim.
+	Audience []string
+	// The lifetime of access tokens. Defaults to 15 minutes.
+	AccessTTL time.Duration
+	// The lifetime of refresh tokens. Every refresh issues a new refresh
+	// token with a new lifetime. Defaults to 30 days.
+	RefreshTTL time.Duration
+	// Holds the refresh tokens. Defaults to a new MemoryRefreshStore.
+	Store RefreshStore
+}
+
+// Grant describes who a token is issued to.
+type Grant struct {
+	// The "sub" claim.
+	Subject string `json:"sub"`
+	// Further claims of the access tokens, ie: "scope" or "roles". They can
+	// not override the registered claims set by the Issuer.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// TokenResponse is the body of the responses of the Issuer's endpoints, as
+// defined by OAuth 2.0 (RFC 6749, section 5.1).
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Issuer signs access tokens and rotates refresh tokens. Each refresh token
+// can be exchanged only once; when a refresh token which was already
+// exchanged is presented again, it was likely stolen and every token issued
+// from the same login (the token family) is revoked.
+type Issuer struct {
+	conf IssuerConfig
+	now  func() time.Time
+}
+
+// NewIssuer returns a new instance of Issuer. It panics if
+// IssuerConfig.Method is missing or "none".
+func NewIssuer(conf IssuerConfig) *Issuer {
+	if conf.Method == nil || conf.Method.Alg() == "none" {
+		panic("tokenware: IssuerConfig.Method must be a signing algorithm")
+	}
+	if conf.AccessTTL == 0 {
+		conf.AccessTTL = 15 * time.Minute
+	}
+	if conf.RefreshTTL == 0 {
+		conf.RefreshTTL = 30 * 24 * time.Hour
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemoryRefreshStore()
+	}
+	return &Issuer{conf: conf, now: time.Now}
+}
+
+// KeySet returns the key which verifies the tokens of the Issuer, for
+// Config.Keys of the middleware.
+func (i *Issuer) KeySet() StaticKeySet {
+	key := i.conf.Key
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	return StaticKeySet{{ID: i.conf.KeyID, Algorithm: i.conf.Method.Alg(), Key: key}}
+}
+
+// AccessToken signs an access token for the grant.
+func (i *Issuer) AccessToken(g Grant) (string, error) {
+	now := i.now()
+	claims := jwt.MapClaims{}
+	for k, v := range g.Claims {
+		claims[k] = v
+	}
+	claims["sub"] = g.Subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(i.conf.AccessTTL).Unix()
+	claims["jti"] = randomString(16)
+	if i.conf.Issuer != "" {
+		claims["iss"] = i.conf.Issuer
+	}
+	switch len(i.conf.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = i.conf.Audience[0]
+	default:
+		claims["aud"] = i.conf.Audience
+	}
+	token := jwt.NewWithClaims(i.conf.Method, claims)
+	if i.conf.KeyID != "" {
+		token.Header["kid"] = i.conf.KeyID
+	}
+	return token.SignedString(i.conf.Key)
+}
+
+// Issue signs an access token and starts a new family of refresh tokens.
+func (i *Issuer) Issue(g Grant) (*TokenResponse, error) {
+	return i.issue(g, randomString(16))
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// It returns ErrInvalidRefreshToken if the refresh token can not be used, and
+// ErrRefreshTokenReused (after revoking the token family) if it was used
+// before.
+func (i *Issuer) Refresh(refreshToken string) (*TokenResponse, error) {
+	rt, err := i.conf.Store.Use(hashToken(refreshToken))
+	if err == ErrRefreshTokenNotFound {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rt.Used {
+		if err := i.conf.Store.RevokeFamily(rt.Family, i.now().Add(i.conf.RefreshTTL)); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if !i.now().Before(rt.Expires) {
+		return nil, ErrInvalidRefreshToken
+	}
+	resp, err := i.issue(rt.Grant, rt.Family)
+	if err == ErrRefreshFamilyRevoked {
+		// The token was reused while this refresh was in progress.
+		return nil, ErrInvalidRefreshToken
+	}
+	return resp, err
+}
+
+// Revoke revokes the family of the refresh token, ie: on logout.
+func (i *Issuer) Revoke(refreshToken string) error {
+	rt, err := i.conf.Store.Get(hashToken(refreshToken))
+	if err == ErrRefreshTokenNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return i.conf.Store.RevokeFamily(rt.Family, i.now().Add(i.conf.RefreshTTL))
+}
+
+func (i *Issuer) issue(g Grant, family string) (*TokenResponse, error) {
+	access, err := i.AccessToken(g)
+	if err != nil {
+		return nil, err
+	}
+	refresh := randomString(32)
+	err = i.conf.Store.Save(RefreshToken{
+		ID:      hashToken(refresh),
+		Family:  family,
+		Grant:   g,
+		Expires: i.now().Add(i.conf.RefreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(i.conf.AccessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// LoginHandler returns the endpoint which issues tokens after a login, ie: the
+// callback of an identity provider or a form post with a password. The
+// authenticate function checks the request and returns the grant. Its errors
+// are returned as they are, so it should return an httpware.Err with a
+// status of 401 (Unauthorized) for failed logins.
+func (i *Issuer) LoginHandler(authenticate func(r *http.Request) (Grant, error)) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		g, err := authenticate(r)
+		if err != nil {
+			return err
+		}
+		resp, err := i.Issue(g)
+		if err != nil {
+			return err
+		}
+		logware.LoggerFromCtx(ctx).AddField("principal", g.Subject)
+		return writeTokenResponse(w, resp)
+	})
+}
+
+// RefreshHandler returns the endpoint which exchanges refresh tokens. It
+// accepts a POST request with the "refresh_token" parameter in a form body
+// (as for the OAuth 2.0 "refresh_token" grant) or in a JSON body.
+func (i *Issuer) RefreshHandler() httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			return httpware.NewErr("method not allowed", http.StatusMethodNotAllowed)
+		}
+		token, err := refreshTokenParam(r)
+		if err != nil {
+			return err
+		}
+		resp, err := i.Refresh(token)
+		if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+			logware.LoggerFromCtx(ctx).AddField("tokenError", err.Error())
+			return httpware.NewErr("invalid refresh token", http.StatusBadRequest).WithField("code", CodeInvalidGrant)
+		}
+		if err != nil {
+			return err
+		}
+		return writeTokenResponse(w, resp)
+	})
+}
+
+func refreshTokenParam(r *http.Request) (string, error) {
+	var token string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return "", httpware.NewErr("malformed request body", http.StatusBadRequest)
+		}
+		token = body.RefreshToken
+	} else {
+		if gt := r.PostFormValue("grant_type"); gt != "" && gt != "refresh_token" {
+			return "", httpware.NewErr("unsupported grant type", http.StatusBadRequest).WithField("code", "unsupported_grant_type")
+		}
+		token = r.PostFormValue("refresh_token")
+	}
+	if token == "" {
+		return "", httpware.NewErr("missing refresh token", http.StatusBadRequest).WithField("code", "invalid_request")
+	}
+	return token, nil
+}
+
+func writeTokenResponse(w http.ResponseWriter, resp *TokenResponse) error {
+	w.Header().Set("Content-Type", "application/json")
+	// Tokens must not be cached (RFC 6749, section 5.1).
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// randomString returns n random bytes, base64url encoded.
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
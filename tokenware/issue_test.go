@@ -0,0 +1,205 @@
+package tokenware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+)
+
+func TestIssuer(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := NewIssuer(IssuerConfig{
+		Method:   jwt.SigningMethodES256,
+		Key:      key,
+		KeyID:    "k1",
+		Issuer:   "https://auth.example.com",
+		Audience: []string{"orders"},
+	})
+	login := httpware.Compose(httpware.DefaultErrHandler).Then(iss.LoginHandler(func(r *http.Request) (Grant, error) {
+		if r.PostFormValue("password") != "secret" {
+			return Grant{}, httpware.NewErr("invalid credentials", http.StatusUnauthorized)
+		}
+		return Grant{Subject: r.PostFormValue("user"), Claims: map[string]interface{}{"scope": "orders:read"}}, nil
+	}))
+	refresh := httpware.Compose(httpware.DefaultErrHandler).Then(iss.RefreshHandler())
+
+	var p *httpware.Principal
+	api := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"ES256"},
+		Keys:       iss.KeySet(),
+		Issuer:     "https://auth.example.com",
+		Audience:   []string{"orders"},
+	})).ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		p = httpware.PrincipalFromCtx(ctx)
+		return nil
+	})
+
+	post := func(h http.Handler, form url.Values) (int, TokenResponse) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(rec, r)
+		var resp TokenResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := post(login, url.Values{"user": {"alice"}, "password": {"wrong"}}); code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %v for a failed login, got %v", http.StatusUnauthorized, code)
+	}
+	code, first := post(login, url.Values{"user": {"alice"}, "password": {"secret"}})
+	if code != http.StatusOK || first.TokenType != "Bearer" || first.ExpiresIn != 900 || first.RefreshToken == "" {
+		t.Fatalf("unexpected login response: %v %+v", code, first)
+	}
+	if code := authorized(api, first.AccessToken); code != http.StatusOK {
+		t.Fatalf("expected the issued token to be accepted, got %v", code)
+	}
+	if p == nil || p.Subject != "alice" || !p.HasScope("orders:read") {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+
+	refreshed := func(token string) (int, TokenResponse) {
+		return post(refresh, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token}})
+	}
+	code, second := refreshed(first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected a rotated refresh token: %v %+v", code, second)
+	}
+	if code := authorized(api, second.AccessToken); code != http.StatusOK {
+		t.Fatalf("expected the refreshed token to be accepted, got %v", code)
+	}
+
+	// Replaying the first refresh token revokes the whole family.
+	if code, _ := refreshed(first.RefreshToken); code != http.StatusBadRequest {
+		t.Fatalf("expected status code %v for a reused refresh token, got %v", http.StatusBadRequest, code)
+	}
+	if code, _ := refreshed(second.RefreshToken); code != http.StatusBadRequest {
+		t.Fatalf("expected the token family to be revoked, got %v", code)
+	}
+
+	// Other logins are unaffected.
+	_, other := post(login, url.Values{"user": {"bob"}, "password": {"secret"}})
+	if _, err := iss.Refresh(other.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iss.Refresh("unknown"); err != ErrInvalidRefreshToken {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefreshExpiry(t *testing.T) {
+	now := time.Now()
+	iss := NewIssuer(IssuerConfig{Method: jwt.SigningMethodHS256, Key: []byte("shh"), RefreshTTL: time.Hour})
+	iss.now = func() time.Time { return now }
+	resp, err := iss.Issue(Grant{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := iss.Refresh(resp.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefreshHandlerJSON(t *testing.T) {
+	iss := NewIssuer(IssuerConfig{Method: jwt.SigningMethodHS256, Key: []byte("shh")})
+	resp, err := iss.Issue(Grant{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := httpware.Compose(httpware.DefaultErrHandler).Then(iss.RefreshHandler())
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"refresh_token":"`+resp.RefreshToken+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %v, got %v", http.StatusOK, rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected Cache-Control no-store, got %q", cc)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status code %v, got %v", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestMemoryRefreshStoreSweep(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryRefreshStore()
+	s.now = func() time.Time { return now }
+	s.Save(RefreshToken{ID: "a", Family: "f1", Expires: now.Add(time.Second)})
+	now = now.Add(2 * time.Second)
+	// Within the sweep interval the expired token is kept.
+	s.Save(RefreshToken{ID: "b", Family: "f2", Expires: now.Add(time.Hour)})
+	if _, err := s.Get("a"); err != nil {
+		t.Fatalf("expected no sweep yet, got %v", err)
+	}
+	now = now.Add(refreshSweepInterval)
+	s.Save(RefreshToken{ID: "c", Family: "f3", Expires: now.Add(time.Hour)})
+	if _, err := s.Get("a"); err != ErrRefreshTokenNotFound {
+		t.Fatalf("expected the expired token to be swept, got %v", err)
+	}
+	if _, err := s.Get("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.families["f1"]; ok {
+		t.Fatal("expected the empty family to be removed")
+	}
+}
+
+func TestRefreshRevokedWhileInProgress(t *testing.T) {
+	i := NewIssuer(IssuerConfig{Method: jwt.SigningMethodHS256, Key: []byte("shh")})
+	resp, err := i.Issue(Grant{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first refresh marks the token as used...
+	rt, err := i.conf.Store.Use(hashToken(resp.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ...a reuse revokes the family...
+	if _, err := i.Refresh(resp.RefreshToken); err != ErrRefreshTokenReused {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	// ...and the first refresh can not save its new token.
+	if _, err := i.issue(rt.Grant, rt.Family); err != ErrRefreshFamilyRevoked {
+		t.Fatalf("expected ErrRefreshFamilyRevoked, got %v", err)
+	}
+}
+
+func TestMemoryRefreshStoreRevoked(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryRefreshStore()
+	s.now = func() time.Time { return now }
+	if err := s.RevokeFamily("f", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(RefreshToken{ID: "a", Family: "f", Expires: now.Add(time.Hour)}); err != ErrRefreshFamilyRevoked {
+		t.Fatalf("expected ErrRefreshFamilyRevoked, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := s.Save(RefreshToken{ID: "b", Family: "g", Expires: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.revoked["f"]; ok {
+		t.Fatal("expected the revoked family to be forgotten")
+	}
+}
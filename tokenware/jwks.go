@@ -0,0 +1,332 @@
+package tokenware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned by a KeySet which has no key with the requested
+// ID.
+var ErrKeyNotFound = errors.New("tokenware: key not found")
+
+// Key is a verification key.
+type Key struct {
+	// The key ID, matched against the "kid" header of tokens.
+	ID string
+	// The algorithm the key is used with, ie: "RS256". Empty means any of the
+	// algorithms accepted by the middleware which suit the key type.
+	Algorithm string
+	// An *rsa.PublicKey, *ecdsa.PublicKey or []byte (HMAC secret).
+	Key interface{}
+}
+
+// KeySet resolves the key which verifies a token from its "kid" header.
+type KeySet interface {
+	// LookupKey returns the key with the given ID, or ErrKeyNotFound. The ID
+	// is empty for tokens without a "kid" header.
+	LookupKey(kid string) (Key, error)
+}
+
+// StaticKeySet is a KeySet held in memory.
+type StaticKeySet []Key
+
+// LookupKey fulfills the KeySet interface. A set with a single key returns it
+// for tokens without a "kid" header.
+func (s StaticKeySet) LookupKey(kid string) (Key, error) {
+	for _, k := range s {
+		if k.ID == kid {
+			return k, nil
+		}
+	}
+	if kid == "" && len(s) == 1 {
+		return s[0], nil
+	}
+	return Key{}, ErrKeyNotFound
+}
+
+// ParseJWKS parses a JSON Web Key Set document (RFC 7517). RSA, EC (P-256,
+// P-384 and P-521) and symmetric ("oct") keys are supported, other keys and
+// keys meant for encryption are skipped.
+func ParseJWKS(data []byte) (StaticKeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("tokenware: parsing JWKS: %v", err)
+	}
+	var keys StaticKeySet
+	for _, j := range doc.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.key()
+		if err != nil {
+			return nil, fmt.Errorf("tokenware: parsing JWK %q: %v", j.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		keys = append(keys, Key{ID: j.Kid, Algorithm: j.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+func (j jwk) key() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(j.K)
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSConfig is used to initialize a new instance of JWKS.
+type JWKSConfig struct {
+	// The URL of the JWKS document, ie: an identity provider's jwks_uri.
+	URL string
+	// The path of a JWKS document, used when URL is empty.
+	File string
+	// How often the document is loaded again in the background. Defaults to
+	// an hour.
+	TTL time.Duration
+	// Tokens with an unknown "kid" trigger a reload, ie: after the identity
+	// provider rotated its keys, but at most once per MinRefreshInterval.
+	// Defaults to a minute.
+	MinRefreshInterval time.Duration
+	// Defaults to an http.Client with a 10 second timeout.
+	Client *http.Client
+	// Called when a reload fails. The keys which were loaded before remain
+	// in use. Defaults to ignoring errors.
+	OnError func(error)
+}
+
+// The maximum size of a JWKS document fetched from a URL.
+const maxJWKSSize = 1 << 20
+
+// JWKS is a KeySet loaded from a JWKS document, which is reloaded to pick up
+// rotated keys. Symmetric ("oct") keys are skipped: a published document
+// must not hold shared secrets, use a StaticKeySet for those.
+type JWKS struct {
+	conf JWKSConfig
+
+	mutex       sync.RWMutex
+	keys        StaticKeySet
+	lastRefresh time.Time
+	// Serializes reloads.
+	refreshMutex sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJWKS loads the JWKS document and starts reloading it in the background.
+// It returns an error if the first load fails.
+func NewJWKS(conf JWKSConfig) (*JWKS, error) {
+	if conf.URL == "" && conf.File == "" {
+		return nil, errors.New("tokenware: JWKSConfig needs a URL or File")
+	}
+	if conf.TTL == 0 {
+		conf.TTL = time.Hour
+	}
+	if conf.MinRefreshInterval == 0 {
+		conf.MinRefreshInterval = time.Minute
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if conf.OnError == nil {
+		conf.OnError = func(error) {}
+	}
+	s := &JWKS{
+		conf: conf,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+// LookupKey fulfills the KeySet interface.
+func (s *JWKS) LookupKey(kid string) (Key, error) {
+	s.mutex.RLock()
+	keys, last := s.keys, s.lastRefresh
+	s.mutex.RUnlock()
+	key, err := keys.LookupKey(kid)
+	if err != ErrKeyNotFound || time.Since(last) < s.conf.MinRefreshInterval {
+		return key, err
+	}
+
+	s.refreshMutex.Lock()
+	// Another request may have reloaded the document while waiting.
+	s.mutex.RLock()
+	refreshed := s.lastRefresh != last
+	s.mutex.RUnlock()
+	if !refreshed {
+		if err := s.refresh(); err != nil {
+			s.conf.OnError(err)
+		}
+	}
+	s.refreshMutex.Unlock()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.keys.LookupKey(kid)
+}
+
+// Refresh loads the JWKS document.
+func (s *JWKS) Refresh() error {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+	return s.refresh()
+}
+
+func (s *JWKS) refresh() error {
+	data, err := s.load()
+	if err == nil {
+		var keys StaticKeySet
+		keys, err = ParseJWKS(data)
+		if err == nil {
+			s.mutex.Lock()
+			s.keys = publicKeys(keys)
+			s.mutex.Unlock()
+		}
+	}
+	// Failed attempts count towards the rate limit as well.
+	s.mutex.Lock()
+	s.lastRefresh = time.Now()
+	s.mutex.Unlock()
+	return err
+}
+
+func (s *JWKS) load() ([]byte, error) {
+	if s.conf.URL == "" {
+		return ioutil.ReadFile(s.conf.File)
+	}
+	resp, err := s.conf.Client.Get(s.conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tokenware: fetching JWKS: unexpected status %v", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("tokenware: fetching JWKS: document is larger than %v bytes", maxJWKSSize)
+	}
+	return data, nil
+}
+
+// publicKeys returns the keys which are not symmetric.
+func publicKeys(keys StaticKeySet) StaticKeySet {
+	var public StaticKeySet
+	for _, k := range keys {
+		if _, ok := k.Key.([]byte); !ok {
+			public = append(public, k)
+		}
+	}
+	return public
+}
+
+// Close stops the background reloading.
+func (s *JWKS) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *JWKS) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.conf.TTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				s.conf.OnError(err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
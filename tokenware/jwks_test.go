@@ -0,0 +1,199 @@
+package tokenware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+)
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "alg": "RS256", "use": "sig",
+		"n": encodeBigInt(key.N), "e": encodeBigInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "alg": "ES256", "crv": "P-256",
+		"x": encodeBigInt(key.X), "y": encodeBigInt(key.Y),
+	}
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func signed(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	tkn := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "user-1"})
+	if kid != "" {
+		tkn.Header["kid"] = kid
+	}
+	s, err := tkn.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func authorized(h httpware.Handler, token string) int {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	httpware.Compose(httpware.DefaultErrHandler).Then(h).ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func okHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func TestJWKSRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	doc := jwksDocument(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	var fetches int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write(doc)
+	}))
+	defer idp.Close()
+
+	keys, err := NewJWKS(JWKSConfig{URL: idp.URL, MinRefreshInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+	h := New(Config{Algorithms: []string{"RS256", "ES256", "HS256"}, Keys: keys}).Handle(httpware.HandlerFunc(okHandler))
+
+	if code := authorized(h, signed(t, jwt.SigningMethodRS256, "rsa-1", rsaKey)); code != http.StatusOK {
+		t.Fatalf("expected RSA token to be accepted, got %v", code)
+	}
+	if code := authorized(h, signed(t, jwt.SigningMethodES256, "ec-1", ecKey)); code != http.StatusOK {
+		t.Fatalf("expected EC token to be accepted, got %v", code)
+	}
+
+	// The identity provider rotates its keys.
+	mutex.Lock()
+	doc = jwksDocument(t, rsaJWK("rsa-2", &rotated.PublicKey))
+	mutex.Unlock()
+	rotatedToken := signed(t, jwt.SigningMethodRS256, "rsa-2", rotated)
+
+	// Unknown kids reload the document at most once per MinRefreshInterval.
+	before := atomic.LoadInt32(&fetches)
+	authorized(h, rotatedToken)
+	authorized(h, signed(t, jwt.SigningMethodRS256, "unknown", rotated))
+	if n := atomic.LoadInt32(&fetches) - before; n != 0 {
+		t.Fatalf("expected no reload within MinRefreshInterval, got %v", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if code := authorized(h, rotatedToken); code != http.StatusOK {
+		t.Fatalf("expected rotated key to be picked up, got %v", code)
+	}
+	if code := authorized(h, signed(t, jwt.SigningMethodRS256, "rsa-1", rsaKey)); code != http.StatusUnauthorized {
+		t.Fatalf("expected retired key to be rejected, got %v", code)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	secret := []byte("shh")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "tokenware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	doc := jwksDocument(t, ecJWK("ec-1", &ecKey.PublicKey), map[string]string{"kty": "oct", "kid": "hmac", "k": base64.RawURLEncoding.EncodeToString(secret)})
+	if err := ioutil.WriteFile(path, doc, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewJWKS(JWKSConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+	h := New(Config{Algorithms: []string{"RS256", "ES256", "HS256"}, Keys: keys}).Handle(httpware.HandlerFunc(okHandler))
+	if code := authorized(h, signed(t, jwt.SigningMethodES256, "ec-1", ecKey)); code != http.StatusOK {
+		t.Fatalf("expected token to be accepted, got %v", code)
+	}
+	if code := authorized(h, signed(t, jwt.SigningMethodHS256, "hmac", secret)); code != http.StatusUnauthorized {
+		t.Fatalf("expected symmetric key to be skipped, got %v", code)
+	}
+
+	if _, err := NewJWKS(JWKSConfig{File: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestJWKSTooLarge(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxJWKSSize+1))
+	}))
+	defer idp.Close()
+
+	if _, err := NewJWKS(JWKSConfig{URL: idp.URL}); err == nil {
+		t.Fatal("expected an error for a document which is too large")
+	}
+}
+
+func TestStaticKeySet(t *testing.T) {
+	keys := StaticKeySet{{ID: "a", Key: []byte("secret-a")}}
+	if k, err := keys.LookupKey(""); err != nil || k.ID != "a" {
+		t.Fatalf("expected the only key for an empty kid, got %v %v", k, err)
+	}
+	if _, err := keys.LookupKey("b"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	parsed, err := ParseJWKS([]byte(`{"keys":[{"kty":"RSA","use":"enc","n":"AQAB","e":"AQAB"},{"kty":"OKP","crv":"Ed25519","x":"AA"}]}`))
+	if err != nil || len(parsed) != 0 {
+		t.Fatalf("expected encryption and unsupported keys to be skipped, got %v %v", parsed, err)
+	}
+	if _, err := ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`)); err == nil {
+		t.Fatal("expected an error for a point which is not on the curve")
+	}
+}
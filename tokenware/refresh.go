@@ -0,0 +1,165 @@
+package tokenware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned by a RefreshStore for unknown
+	// tokens.
+	ErrRefreshTokenNotFound = errors.New("tokenware: refresh token not found")
+	// ErrInvalidRefreshToken is returned by Issuer.Refresh for refresh tokens
+	// which are unknown, expired or revoked.
+	ErrInvalidRefreshToken = errors.New("tokenware: invalid refresh token")
+	// ErrRefreshTokenReused is returned by Issuer.Refresh for refresh tokens
+	// which were exchanged before. The token family has been revoked.
+	ErrRefreshTokenReused = errors.New("tokenware: refresh token reused, token family revoked")
+	// ErrRefreshFamilyRevoked is returned by a RefreshStore when saving a
+	// token of a revoked family.
+	ErrRefreshFamilyRevoked = errors.New("tokenware: refresh token family revoked")
+)
+
+// RefreshToken is a refresh token as it is stored. The token itself is not
+// stored, only its hash.
+type RefreshToken struct {
+	// The hex encoded SHA-256 hash of the token.
+	ID string
+	// The family of the token. All refresh tokens issued from one login
+	// belong to the same family.
+	Family  string
+	Grant   Grant
+	Expires time.Time
+	// Whether the token was exchanged already.
+	Used bool
+}
+
+// RefreshStore holds the refresh tokens of an Issuer. Sharing a RefreshStore
+// between several processes lets any of them refresh the tokens.
+type RefreshStore interface {
+	// Save stores a new refresh token. It returns ErrRefreshFamilyRevoked
+	// for the tokens of a family which was revoked, see RevokeFamily.
+	Save(t RefreshToken) error
+	// Get returns the token with the given ID, or ErrRefreshTokenNotFound.
+	Get(id string) (RefreshToken, error)
+	// Use atomically marks the token with the given ID as used and returns
+	// it as it was before, or ErrRefreshTokenNotFound. Used tokens must be
+	// kept until they expire, so that their reuse is detected.
+	Use(id string) (RefreshToken, error)
+	// RevokeFamily deletes all of the tokens of the family, and remembers
+	// the family as revoked until the given time. A refresh which used a
+	// token of the family just before it was revoked must not be able to
+	// save the new token, or the family would live on.
+	RevokeFamily(family string, until time.Time) error
+}
+
+// MemoryRefreshStore is a RefreshStore which keeps the tokens in the memory
+// of the current process.
+type MemoryRefreshStore struct {
+	mutex    sync.Mutex
+	tokens   map[string]RefreshToken
+	families map[string]map[string]bool
+	// When the revoked families are forgotten.
+	revoked map[string]time.Time
+	swept   time.Time
+	now     func() time.Time
+}
+
+// refreshSweepInterval is how often MemoryRefreshStore removes expired
+// tokens.
+const refreshSweepInterval = time.Minute
+
+// NewMemoryRefreshStore returns a new, empty MemoryRefreshStore.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens:   make(map[string]RefreshToken),
+		families: make(map[string]map[string]bool),
+		revoked:  make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Save fulfills the RefreshStore interface. Expired tokens and revoked
+// families are removed while saving, at most once a minute.
+func (s *MemoryRefreshStore) Save(t RefreshToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if now.Sub(s.swept) >= refreshSweepInterval {
+		for id, old := range s.tokens {
+			if !now.Before(old.Expires) {
+				s.delete(old)
+				delete(s.tokens, id)
+			}
+		}
+		for family, until := range s.revoked {
+			if !now.Before(until) {
+				delete(s.revoked, family)
+			}
+		}
+		s.swept = now
+	}
+	if until, ok := s.revoked[t.Family]; ok && now.Before(until) {
+		return ErrRefreshFamilyRevoked
+	}
+	s.tokens[t.ID] = t
+	if s.families[t.Family] == nil {
+		s.families[t.Family] = make(map[string]bool)
+	}
+	s.families[t.Family][t.ID] = true
+	return nil
+}
+
+// Get fulfills the RefreshStore interface.
+func (s *MemoryRefreshStore) Get(id string) (RefreshToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	return t, nil
+}
+
+// Use fulfills the RefreshStore interface.
+func (s *MemoryRefreshStore) Use(id string) (RefreshToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	used := t
+	used.Used = true
+	s.tokens[id] = used
+	return t, nil
+}
+
+// RevokeFamily fulfills the RefreshStore interface.
+func (s *MemoryRefreshStore) RevokeFamily(family string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id := range s.families[family] {
+		delete(s.tokens, id)
+	}
+	delete(s.families, family)
+	s.revoked[family] = until
+	return nil
+}
+
+// delete removes the token from its family.
+func (s *MemoryRefreshStore) delete(t RefreshToken) {
+	ids := s.families[t.Family]
+	delete(ids, t.ID)
+	if len(ids) == 0 {
+		delete(s.families, t.Family)
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,375 @@
+package tokenware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// CodeTokenRevoked is the "code" of errors for revoked tokens.
+const CodeTokenRevoked = "token_revoked"
+
+// Revocation is a deny-list of tokens which were revoked before they expired.
+// It is checked by the middleware for every token, see Config.Revocation.
+type Revocation interface {
+	// RevokeToken revokes the token with the "jti" claim. The entry can be
+	// dropped at expires, the expiry of the token. A zero expires keeps it
+	// forever.
+	RevokeToken(jti string, expires time.Time) error
+	// RevokeSubject revokes the tokens of the subject ("sub" claim) which were
+	// issued ("iat" claim) before the cut-off, ie: after a password change.
+	// Times are compared in whole seconds, the precision of "iat".
+	// The entry can be dropped at expires, when the last of these tokens
+	// expired. A zero expires keeps it forever.
+	RevokeSubject(sub string, before, expires time.Time) error
+	// Revoked reports whether the token with the claims is revoked. A zero
+	// issuedAt is before any cut-off.
+	Revoked(jti, sub string, issuedAt time.Time) (bool, error)
+}
+
+// MemoryRevocation is a Revocation which keeps the revoked tokens in the
+// memory of the current process. A bloom filter answers for tokens which
+// are not revoked, the common case, without taking a lock.
+type MemoryRevocation struct {
+	mutex    sync.Mutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectCutoff
+	filter   atomic.Value // *bloom
+	now      func() time.Time
+}
+
+type subjectCutoff struct {
+	before  time.Time
+	expires time.Time
+}
+
+// NewMemoryRevocation returns a new, empty MemoryRevocation.
+func NewMemoryRevocation() *MemoryRevocation {
+	r := &MemoryRevocation{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectCutoff),
+		now:      time.Now,
+	}
+	r.filter.Store(newBloom(0))
+	return r
+}
+
+// RevokeToken fulfills the Revocation interface.
+func (r *MemoryRevocation) RevokeToken(jti string, expires time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokens[jti] = expires
+	r.added("jti:" + jti)
+	return nil
+}
+
+// RevokeSubject fulfills the Revocation interface.
+func (r *MemoryRevocation) RevokeSubject(sub string, before, expires time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c, ok := r.subjects[sub]; ok && c.before.After(before) {
+		before = c.before
+	}
+	r.subjects[sub] = subjectCutoff{before: before, expires: expires}
+	r.added("sub:" + sub)
+	return nil
+}
+
+// Revoked fulfills the Revocation interface.
+func (r *MemoryRevocation) Revoked(jti, sub string, issuedAt time.Time) (bool, error) {
+	filter := r.filter.Load().(*bloom)
+	checkToken := jti != "" && filter.mayContain("jti:"+jti)
+	checkSubject := sub != "" && filter.mayContain("sub:"+sub)
+	if !checkToken && !checkSubject {
+		return false, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.now()
+	if checkToken {
+		if expires, ok := r.tokens[jti]; ok && !expired(expires, now) {
+			return true, nil
+		}
+	}
+	if checkSubject {
+		// Compared in whole seconds, the precision of "iat".
+		if c, ok := r.subjects[sub]; ok && !expired(c.expires, now) && issuedAt.Unix() < c.before.Unix() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// added adds the key to the filter. The filter is rebuilt without the
+// expired entries when it gets too full.
+func (r *MemoryRevocation) added(key string) {
+	filter := r.filter.Load().(*bloom)
+	if filter.add(key) {
+		return
+	}
+	now := r.now()
+	for jti, expires := range r.tokens {
+		if expired(expires, now) {
+			delete(r.tokens, jti)
+		}
+	}
+	for sub, c := range r.subjects {
+		if expired(c.expires, now) {
+			delete(r.subjects, sub)
+		}
+	}
+	filter = newBloom(len(r.tokens) + len(r.subjects))
+	for jti := range r.tokens {
+		filter.add("jti:" + jti)
+	}
+	for sub := range r.subjects {
+		filter.add("sub:" + sub)
+	}
+	r.filter.Store(filter)
+}
+
+func expired(expires, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// bloom is a bloom filter which can be read concurrently with add. Its size
+// is chosen for a false positive rate below 1%.
+type bloom struct {
+	bits     []uint64
+	capacity int
+	count    int32
+}
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+func newBloom(n int) *bloom {
+	// Leave room to grow.
+	capacity := 1024
+	for capacity < 2*n {
+		capacity *= 2
+	}
+	return &bloom{
+		bits:     make([]uint64, (capacity*bloomBitsPerKey+63)/64),
+		capacity: capacity,
+	}
+}
+
+// add adds the key and reports whether the filter still has room for it.
+func (b *bloom) add(key string) bool {
+	if int(atomic.AddInt32(&b.count, 1)) > b.capacity {
+		return false
+	}
+	h1, h2 := bloomHash(key)
+	m := uint64(len(b.bits) * 64)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		word, mask := &b.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	return true
+}
+
+func (b *bloom) mayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(b.bits) * 64)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if atomic.LoadUint64(&b.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash returns the two hashes which derive the positions of a key
+// (Kirsch and Mitzenmacher).
+func bloomHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum, sum>>32 | 1
+}
+
+// FileRevocation is a MemoryRevocation which is saved to a file, so that
+// revocations survive restarts. The file is written on every revocation.
+type FileRevocation struct {
+	*MemoryRevocation
+	path      string
+	fileMutex sync.Mutex
+}
+
+type revocationFile struct {
+	Tokens   map[string]time.Time           `json:"tokens"`
+	Subjects map[string]revocationFileEntry `json:"subjects"`
+}
+
+type revocationFileEntry struct {
+	Before  time.Time `json:"before"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileRevocation loads the revocations saved at path, if the file exists.
+func NewFileRevocation(path string) (*FileRevocation, error) {
+	r := &FileRevocation{MemoryRevocation: NewMemoryRevocation(), path: path}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(bs) > 0 {
+		var saved revocationFile
+		if err := json.Unmarshal(bs, &saved); err != nil {
+			return nil, fmt.Errorf("tokenware: reading %s: %v", path, err)
+		}
+		for jti, expires := range saved.Tokens {
+			r.MemoryRevocation.RevokeToken(jti, expires)
+		}
+		for sub, e := range saved.Subjects {
+			r.MemoryRevocation.RevokeSubject(sub, e.Before, e.Expires)
+		}
+	}
+	return r, nil
+}
+
+// RevokeToken fulfills the Revocation interface.
+func (r *FileRevocation) RevokeToken(jti string, expires time.Time) error {
+	r.MemoryRevocation.RevokeToken(jti, expires)
+	return r.save()
+}
+
+// RevokeSubject fulfills the Revocation interface.
+func (r *FileRevocation) RevokeSubject(sub string, before, expires time.Time) error {
+	r.MemoryRevocation.RevokeSubject(sub, before, expires)
+	return r.save()
+}
+
+// save writes the revocations which have not expired. The file is replaced
+// atomically, so a crash while saving leaves the previous version in place.
+func (r *FileRevocation) save() error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	r.mutex.Lock()
+	now := r.now()
+	saved := revocationFile{
+		Tokens:   make(map[string]time.Time),
+		Subjects: make(map[string]revocationFileEntry),
+	}
+	for jti, expires := range r.tokens {
+		if !expired(expires, now) {
+			saved.Tokens[jti] = expires
+		}
+	}
+	for sub, c := range r.subjects {
+		if !expired(c.expires, now) {
+			saved.Subjects[sub] = revocationFileEntry{Before: c.before, Expires: c.expires}
+		}
+	}
+	r.mutex.Unlock()
+
+	bs, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// RevocationHandler returns an admin endpoint which revokes tokens. It must
+// be protected, ie: with authzware.RequireRoles("admin"). It accepts a POST
+// request with a JSON body which holds either:
+//
+//	{"token": "<JWT>"}                  the token, until its "exp"
+//	{"jti": "<ID>", "exp": 1700000000}  the token with the ID
+//	{"sub": "<subject>", "before": 1700000000, "exp": 1700003600}
+//	                                    the tokens of the subject issued before
+//	                                    "before" (defaults to now)
+//
+// Times are NumericDates (seconds since the epoch), a missing "exp" keeps the
+// entry forever. It responds with 204 (No Content).
+func RevocationHandler(rev Revocation) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			return httpware.NewErr("method not allowed", http.StatusMethodNotAllowed)
+		}
+		var req struct {
+			Token   string `json:"token"`
+			JTI     string `json:"jti"`
+			Subject string `json:"sub"`
+			Before  int64  `json:"before"`
+			Expires int64  `json:"exp"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return httpware.NewErr("malformed request body", http.StatusBadRequest)
+		}
+		if req.Token != "" {
+			claims, err := decodePayload(req.Token)
+			if err != nil {
+				return httpware.NewErr("malformed token", http.StatusBadRequest)
+			}
+			req.JTI, _ = claims["jti"].(string)
+			if exp, ok, _ := numericDate(claims, "exp"); ok {
+				req.Expires = exp.Unix()
+			}
+			if req.JTI == "" {
+				return httpware.NewErr("the token has no jti claim", http.StatusBadRequest)
+			}
+		}
+		var expires time.Time
+		if req.Expires != 0 {
+			expires = time.Unix(req.Expires, 0)
+		}
+
+		var err error
+		switch {
+		case req.JTI != "":
+			err = rev.RevokeToken(req.JTI, expires)
+		case req.Subject != "":
+			// "iat" is in whole seconds, tokens issued later in the
+			// current second are not revoked.
+			before := time.Now().Truncate(time.Second)
+			if req.Before != 0 {
+				before = time.Unix(req.Before, 0)
+			}
+			err = rev.RevokeSubject(req.Subject, before, expires)
+		default:
+			return httpware.NewErr("a token, jti or sub is required", http.StatusBadRequest)
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
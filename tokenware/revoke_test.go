@@ -0,0 +1,183 @@
+package tokenware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nstogner/httpware"
+)
+
+func TestRevocation(t *testing.T) {
+	secret := []byte("shh")
+	rev := NewMemoryRevocation()
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+		Revocation: rev,
+	})).ThenFunc(okHandler)
+	sign := func(claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+	stolen := sign(jwt.MapClaims{"jti": "t1", "sub": "alice", "iat": now.Unix(), "exp": exp})
+	other := sign(jwt.MapClaims{"jti": "t2", "sub": "alice", "iat": now.Unix(), "exp": exp})
+	old := sign(jwt.MapClaims{"jti": "t3", "sub": "bob", "iat": now.Add(-time.Hour).Unix(), "exp": exp})
+	fresh := sign(jwt.MapClaims{"jti": "t4", "sub": "bob", "iat": now.Unix(), "exp": exp})
+
+	admin := httpware.Compose(httpware.DefaultErrHandler).Then(RevocationHandler(rev))
+	revoke := func(body string) int {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := revoke(`{"token":"` + stolen + `"}`); code != http.StatusNoContent {
+		t.Fatalf("expected status code %v, got %v", http.StatusNoContent, code)
+	}
+	if code := revoke(fmt.Sprintf(`{"sub":"bob","before":%v,"exp":%v}`, now.Add(-time.Minute).Unix(), exp)); code != http.StatusNoContent {
+		t.Fatalf("expected status code %v, got %v", http.StatusNoContent, code)
+	}
+	if code := revoke(`{}`); code != http.StatusBadRequest {
+		t.Fatalf("expected status code %v, got %v", http.StatusBadRequest, code)
+	}
+
+	cases := []struct {
+		Name   string
+		Token  string
+		Status int
+	}{
+		{"revoked jti", stolen, http.StatusUnauthorized},
+		{"same subject", other, http.StatusOK},
+		{"issued before cut-off", old, http.StatusUnauthorized},
+		{"issued after cut-off", fresh, http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := authorized(h, c.Token); code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, code)
+		}
+	}
+}
+
+func TestRevocationExpiry(t *testing.T) {
+	now := time.Now()
+	rev := NewMemoryRevocation()
+	rev.now = func() time.Time { return now }
+	rev.RevokeToken("t1", now.Add(time.Minute))
+	if revoked, _ := rev.Revoked("t1", "", time.Time{}); !revoked {
+		t.Fatal("expected the token to be revoked")
+	}
+	now = now.Add(time.Hour)
+	if revoked, _ := rev.Revoked("t1", "", time.Time{}); revoked {
+		t.Fatal("expected the entry to expire with the token")
+	}
+}
+
+func TestRevocationFilterGrowth(t *testing.T) {
+	rev := NewMemoryRevocation()
+	for i := 0; i < 5000; i++ {
+		rev.RevokeToken(fmt.Sprint("t", i), time.Time{})
+	}
+	for i := 0; i < 5000; i++ {
+		if revoked, _ := rev.Revoked(fmt.Sprint("t", i), "", time.Time{}); !revoked {
+			t.Fatalf("expected t%v to be revoked", i)
+		}
+	}
+	var positives int
+	for i := 0; i < 10000; i++ {
+		if rev.filter.Load().(*bloom).mayContain(fmt.Sprint("jti:u", i)) {
+			positives++
+		}
+	}
+	if positives > 200 {
+		t.Fatalf("expected a false positive rate below 2%%, got %v in 10000", positives)
+	}
+}
+
+func TestFileRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokenware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.json")
+	rev, err := NewFileRevocation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rev.RevokeToken("t1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rev.RevokeSubject("alice", time.Now(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewFileRevocation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := loaded.Revoked("t1", "", time.Time{}); !revoked {
+		t.Fatal("expected the token to stay revoked")
+	}
+	if revoked, _ := loaded.Revoked("t2", "alice", time.Now().Add(-time.Minute)); !revoked {
+		t.Fatal("expected the subject to stay revoked")
+	}
+	if revoked, _ := loaded.Revoked("t2", "bob", time.Time{}); revoked {
+		t.Fatal("expected other tokens to be accepted")
+	}
+}
+
+func BenchmarkRevoked(b *testing.B) {
+	rev := NewMemoryRevocation()
+	for i := 0; i < 1000; i++ {
+		rev.RevokeToken(fmt.Sprint("t", i), time.Time{})
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rev.Revoked("valid", "alice", time.Time{})
+		}
+	})
+}
+
+func TestRevocationSameSecond(t *testing.T) {
+	rev := NewMemoryRevocation()
+	rev.RevokeSubject("alice", time.Unix(100, 500e6), time.Time{})
+	if revoked, _ := rev.Revoked("", "alice", time.Unix(100, 0)); revoked {
+		t.Fatal("expected a token issued in the second of the cut-off to be accepted")
+	}
+	if revoked, _ := rev.Revoked("", "alice", time.Unix(99, 0)); !revoked {
+		t.Fatal("expected a token issued before the cut-off to be revoked")
+	}
+
+	// A login right after the revocation, ie: after a password change.
+	iss := NewIssuer(IssuerConfig{Method: jwt.SigningMethodHS256, Key: []byte("shh")})
+	rev = NewMemoryRevocation()
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{
+		Algorithms: []string{"HS256"},
+		Secret:     []byte("shh"),
+		Revocation: rev,
+	})).ThenFunc(okHandler)
+	rec := httptest.NewRecorder()
+	httpware.Compose(httpware.DefaultErrHandler).Then(RevocationHandler(rev)).ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"sub":"alice"}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %v, got %v", http.StatusNoContent, rec.Code)
+	}
+	token, err := iss.AccessToken(Grant{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := authorized(h, token); code != http.StatusOK {
+		t.Fatalf("expected the new token to be accepted, got %v", code)
+	}
+}
@@ -1,62 +1,234 @@
 /*
 Package tokenware provides middleware for decoding & verifying Json Web Tokens
 (JWT's) from http requests. It implements the httpware.Middleware interface for
-easy composition with other middleware.
+easy composition with other middleware. An Issuer signs tokens and rotates
+refresh tokens for services which issue their own tokens, Introspection
+validates opaque tokens with the authorization server (RFC 7662).
 */
 package tokenware
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
 )
 
 // Config is used to initialize a new instance of this middleware.
 type Config struct {
-	// The secret should be the same that was used to sign the token.
+	// The algorithms of the tokens which are accepted, ie: "RS256". It is
+	// required, tokens signed with any other algorithm are rejected. "none"
+	// is never accepted.
+	Algorithms []string
+	// The secret should be the same that was used to sign the token. It is
+	// only used with the algorithms which suit its type: a []byte for HMAC,
+	// an *rsa.PublicKey for RSA and an *ecdsa.PublicKey for ECDSA.
 	Secret interface{}
+	// Keys resolves the verification key from the "kid" header of the token,
+	// ie: a JWKS of an identity provider which rotates its keys. It takes
+	// precedence over Secret.
+	Keys KeySet
+	// The accepted "iss" claim. Empty accepts any issuer.
+	Issuer string
+	// The "aud" claim must contain one of these values. Empty accepts any
+	// audience.
+	Audience []string
+	// Claims which must be present, ie: "sub".
+	RequiredClaims []string
+	// The clock skew which is tolerated when checking the "exp", "nbf" and
+	// "iat" claims.
+	Leeway time.Duration
+	// Returns a new instance of the application's claims type, which is
+	// decoded into and returned by ClaimsFromCtx, ie:
+	//
+	//	func() jwt.Claims { return &MyClaims{} }
+	//
+	// Defaults to jwt.MapClaims. The Valid method of the claims is not used,
+	// the claims are validated according to this config.
+	NewClaims func() jwt.Claims
+	// Where the token is looked for, in order, ie:
+	//
+	//	[]tokenware.Extractor{tokenware.BearerToken, tokenware.FromCookie("session")}
+	//
+	// Defaults to BearerToken.
+	Extractors []Extractor
+	// Revoked tokens are rejected, see Revocation.
+	Revocation Revocation
+	// Let requests without a token through, without a token in the context.
+	// Requests with an invalid token are still rejected.
+	Optional bool
 }
 
-// TokenFromCtx retrieves the decoded JWT.
+// TokenFromCtx retrieves the decoded JWT. It returns nil for anonymous
+// requests, see Config.Optional.
 func TokenFromCtx(ctx context.Context) *jwt.Token {
-	return ctx.Value(httpware.TokenKey).(*jwt.Token)
+	token, _ := ctx.Value(httpware.TokenKey).(*jwt.Token)
+	return token
 }
 
-// Middle parses the JWT in the 'Authorization' header. It will
-// return an 'Unauthorized' response if the token is missing or invalid.
+// ClaimsFromCtx retrieves the claims of the decoded JWT. They are of the type
+// returned by Config.NewClaims, ie:
+//
+//	claims := tokenware.ClaimsFromCtx(ctx).(*MyClaims)
+//
+// It returns nil for anonymous requests.
+func ClaimsFromCtx(ctx context.Context) jwt.Claims {
+	token := TokenFromCtx(ctx)
+	if token == nil {
+		return nil
+	}
+	return token.Claims
+}
+
+// Middle parses the JWT found by the configured extractors. It will
+// return an 'Unauthorized' response if the token is missing or invalid, with
+// one of the Code constants in the "code" field. The detailed reason is not
+// sent to the client, it is added to the logware entry of the request as
+// "tokenError". The client of a valid token is stored in the context as an
+// httpware.Principal, with the scopes of the "scope" or "scp" claim and the
+// roles of the "roles" claim.
 type Middle struct {
-	conf Config
+	conf      Config
+	parser    *jwt.Parser
+	extractor request.Extractor
 }
 
-// New returns a new instance of the middleware.
+// New returns a new instance of the middleware. It panics if
+// Config.Algorithms is empty or contains "none".
 func New(conf Config) *Middle {
+	if len(conf.Algorithms) == 0 {
+		panic("tokenware: Config.Algorithms must list the accepted algorithms")
+	}
+	for _, alg := range conf.Algorithms {
+		if alg == "none" {
+			panic(`tokenware: the "none" algorithm can not be accepted`)
+		}
+	}
+	if len(conf.Extractors) == 0 {
+		conf.Extractors = []Extractor{BearerToken}
+	}
+	if conf.NewClaims == nil {
+		conf.NewClaims = func() jwt.Claims { return jwt.MapClaims{} }
+	}
 	return &Middle{
 		// Note: A config struct is used here so that backwards compatibility
 		// can be maintained in the API if new fields need to be added later.
-		conf: conf,
+		conf:      conf,
+		parser:    &jwt.Parser{ValidMethods: conf.Algorithms, SkipClaimsValidation: true},
+		extractor: request.MultiExtractor(conf.Extractors),
 	}
 }
 
 // Handle takes the next handler as an argument and wraps it in this middleware.
 func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
 	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-		token, err := request.ParseFromRequest(
-			r,
-			request.AuthorizationHeaderExtractor,
-			func(token *jwt.Token) (interface{}, error) {
-				return m.conf.Secret, nil
-			},
-		)
-
-		if err == nil && token.Valid {
-			newCtx := context.WithValue(ctx, httpware.TokenKey, token)
-			return next.ServeHTTPCtx(newCtx, w, r)
+		newCtx, err := m.Authenticate(ctx, r)
+		if err == httpware.ErrNoCredentials && m.conf.Optional {
+			return next.ServeHTTPCtx(ctx, w, r)
 		}
-
-		// No soup for you.
-		return httpware.NewErr("invalid token", http.StatusUnauthorized)
+		if err != nil {
+			// No soup for you.
+			w.Header().Set("WWW-Authenticate", m.Challenge(err))
+			if err == httpware.ErrNoCredentials {
+				return httpware.NewErr("invalid token", http.StatusUnauthorized).WithField("code", CodeMissingToken)
+			}
+			return err
+		}
+		return next.ServeHTTPCtx(newCtx, w, r)
 	})
 }
+
+// Authenticate fulfills the httpware.Authenticator interface, so that the
+// middleware can be combined with others by httpware.AnyOf. Config.Optional
+// does not apply.
+func (m *Middle) Authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	token, err := request.ParseFromRequest(
+		r,
+		m.extractor,
+		m.keyFunc,
+		request.WithParser(m.parser),
+		request.WithClaims(m.conf.NewClaims()),
+	)
+	if err == request.ErrNoTokenInRequest {
+		return ctx, httpware.ErrNoCredentials
+	}
+	var claims map[string]interface{}
+	if err == nil {
+		claims, err = decodePayload(token.Raw)
+	}
+	if err == nil {
+		err = m.validateClaims(claims)
+	}
+	if err == nil && m.conf.Revocation != nil {
+		err = m.checkRevoked(claims)
+	}
+	if err != nil {
+		logware.LoggerFromCtx(ctx).AddField("tokenError", err.Error())
+		return ctx, httpware.NewErr("invalid token", http.StatusUnauthorized).WithField("code", errorCode(err))
+	}
+
+	p := principal(claims)
+	logware.LoggerFromCtx(ctx).AddField("principal", p.Subject)
+	ctx = context.WithValue(ctx, httpware.TokenKey, token)
+	return context.WithValue(ctx, httpware.PrincipalKey, p), nil
+}
+
+// Challenge fulfills the httpware.Authenticator interface.
+func (m *Middle) Challenge(err error) string {
+	if err == httpware.ErrNoCredentials {
+		return "Bearer"
+	}
+	return `Bearer error="invalid_token"`
+}
+
+// keyFunc returns the key which verifies the token. The parser has already
+// checked that the algorithm is accepted, this checks that it suits the key.
+func (m *Middle) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := Key{Key: m.conf.Secret}
+	if m.conf.Keys != nil {
+		kid, _ := token.Header["kid"].(string)
+		var err error
+		key, err = m.conf.Keys.LookupKey(kid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	alg := token.Method.Alg()
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return nil, fmt.Errorf("tokenware: key %q is bound to %s, the token uses %s", key.ID, key.Algorithm, alg)
+	}
+	if !suitsKey(alg, key.Key) {
+		return nil, fmt.Errorf("tokenware: the %T key of %q can not verify %s", key.Key, key.ID, alg)
+	}
+	return key.Key, nil
+}
+
+// suitsKey reports whether keys of the type of key are used with alg, so that
+// ie: an RSA public key can not be used as an HMAC secret.
+func suitsKey(alg string, key interface{}) bool {
+	switch k := key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS") && len(k) > 0
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+	}
+	return false
+}
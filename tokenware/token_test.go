@@ -2,19 +2,24 @@ package tokenware
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nstogner/httpware"
+	"github.com/nstogner/httpware/logware"
 )
 
 func TestWare(t *testing.T) {
 	secret := []byte("shh")
 	m := httpware.Compose(
 		httpware.DefaultErrHandler,
-		New(Config{secret}),
+		New(Config{Algorithms: []string{"HS256"}, Secret: secret}),
 	)
 	s := httptest.NewServer(m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		return nil
@@ -51,3 +56,80 @@ func TestWare(t *testing.T) {
 		t.Fatalf("expected status code %v, got %v", http.StatusOK, resp.StatusCode)
 	}
 }
+
+func TestAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+
+	var logged []string
+	logger := logware.LoggerFunc(func(level logware.Level, msg string, fields logware.Fields) {
+		if reason, ok := fields["tokenError"].(string); ok {
+			logged = append(logged, reason)
+		}
+	})
+	serve := func(conf Config, token string) *httptest.ResponseRecorder {
+		m := httpware.Compose(
+			httpware.DefaultErrHandler,
+			logware.New(logware.Config{Logger: logger, End: true}),
+			New(conf),
+		)
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Accept", "application/json")
+		m.ThenFunc(okHandler).ServeHTTP(rec, r)
+		return rec
+	}
+
+	rsaConf := Config{Algorithms: []string{"RS256", "HS256"}, Secret: &rsaKey.PublicKey}
+	noneToken, err := jwt.New(jwt.SigningMethodNone).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boundKeys := StaticKeySet{{ID: "k", Algorithm: "HS512", Key: []byte("secret")}}
+
+	cases := []struct {
+		Name   string
+		Conf   Config
+		Token  string
+		Status int
+	}{
+		{"RSA", rsaConf, signed(t, jwt.SigningMethodRS256, "", rsaKey), http.StatusOK},
+		{"HMAC with the public key", rsaConf, signed(t, jwt.SigningMethodHS256, "", pemBytes), http.StatusUnauthorized},
+		{"none", rsaConf, noneToken, http.StatusUnauthorized},
+		{"algorithm not accepted", Config{Algorithms: []string{"RS256"}, Secret: &rsaKey.PublicKey}, signed(t, jwt.SigningMethodRS512, "", rsaKey), http.StatusUnauthorized},
+		{"key bound to another algorithm", Config{Algorithms: []string{"HS256", "HS512"}, Keys: boundKeys}, signed(t, jwt.SigningMethodHS256, "k", []byte("secret")), http.StatusUnauthorized},
+		{"key bound to the algorithm", Config{Algorithms: []string{"HS256", "HS512"}, Keys: boundKeys}, signed(t, jwt.SigningMethodHS512, "k", []byte("secret")), http.StatusOK},
+	}
+	for _, c := range cases {
+		logged = nil
+		rec := serve(c.Conf, c.Token)
+		if rec.Code != c.Status {
+			t.Fatalf("%s: expected status code %v, got %v", c.Name, c.Status, rec.Code)
+		}
+		if c.Status == http.StatusUnauthorized {
+			if len(logged) != 1 {
+				t.Fatalf("%s: expected the reason to be logged, got %v", c.Name, logged)
+			}
+			if body := rec.Body.String(); body != `{"message":"invalid token","fields":{"code":"invalid_token"}}`+"\n" {
+				t.Fatalf("%s: expected the reason not to be sent, got %s", c.Name, body)
+			}
+		}
+	}
+}
+
+func TestAlgorithmsRequired(t *testing.T) {
+	for _, algs := range [][]string{nil, {"HS256", "none"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected New to panic for %v", algs)
+				}
+			}()
+			New(Config{Algorithms: algs, Secret: []byte("shh")})
+		}()
+	}
+}
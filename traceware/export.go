@@ -0,0 +1,76 @@
+package traceware
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Exporter receives the spans which are sampled once they end. Export is
+// called from the request goroutines so it must be safe for concurrent use
+// and should not block for long.
+type Exporter interface {
+	Export(*Span)
+}
+
+// ExporterFunc is an adapter to allow the use of ordinary functions as an
+// Exporter.
+type ExporterFunc func(*Span)
+
+// Export calls f(s).
+func (f ExporterFunc) Export(s *Span) {
+	f(s)
+}
+
+// JSONExporter writes each span as a line of JSON, ie: to os.Stdout.
+type JSONExporter struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+}
+
+// NewJSONExporter returns a new instance of JSONExporter.
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(out)}
+}
+
+type jsonSpan struct {
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"traceId"`
+	SpanID        string                 `json:"spanId"`
+	ParentID      string                 `json:"parentId,omitempty"`
+	TraceState    string                 `json:"traceState,omitempty"`
+	Start         time.Time              `json:"start"`
+	DurationMs    float64                `json:"durationMs"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+}
+
+var (
+	kindNames   = map[SpanKind]string{KindInternal: "internal", KindServer: "server", KindClient: "client"}
+	statusNames = map[StatusCode]string{StatusUnset: "unset", StatusOK: "ok", StatusError: "error"}
+)
+
+// Export fulfills the Exporter interface.
+func (e *JSONExporter) Export(s *Span) {
+	js := jsonSpan{
+		Name:          s.Name,
+		Kind:          kindNames[s.Kind],
+		TraceID:       s.TraceID.String(),
+		SpanID:        s.SpanID.String(),
+		TraceState:    s.State,
+		Start:         s.Start,
+		DurationMs:    float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+		Attributes:    s.Attributes,
+		Status:        statusNames[s.Status],
+		StatusMessage: s.StatusMessage,
+	}
+	if s.ParentID.IsValid() {
+		js.ParentID = s.ParentID.String()
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.enc.Encode(js)
+}
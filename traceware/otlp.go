@@ -0,0 +1,281 @@
+package traceware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLPConfig is used to initialize a new instance of OTLPExporter.
+type OTLPConfig struct {
+	// The traces endpoint of the collector. Defaults to
+	// "http://localhost:4318/v1/traces".
+	Endpoint string
+	// Extra headers sent with each request, ie: for authentication.
+	Headers map[string]string
+	// The service.name resource attribute.
+	ServiceName string
+	// The number of spans which are sent together. Defaults to 512.
+	BatchSize int
+	// How often the spans which are waiting are sent. Defaults to 5 seconds.
+	FlushInterval time.Duration
+	// The maximum number of spans waiting to be sent. When the collector is
+	// slow or down and the queue is full, the oldest spans are dropped.
+	// Defaults to 2048.
+	MaxQueueSize int
+	// Defaults to an http.Client with a 10 second timeout.
+	Client *http.Client
+	// Called when spans could not be sent. Defaults to ignoring errors.
+	OnError func(error)
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding. Spans are sent in batches from a background
+// goroutine, Close must be called to send the last batch. Spans are not
+// retried: the spans of a batch which can not be sent are lost, as are the
+// oldest spans when the queue is full. They are counted by Dropped.
+type OTLPExporter struct {
+	conf OTLPConfig
+
+	mutex   sync.Mutex
+	pending []*Span
+	dropped uint64
+	// Wakes the background goroutine when a batch is full.
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewOTLPExporter returns a new instance of OTLPExporter.
+func NewOTLPExporter(conf OTLPConfig) *OTLPExporter {
+	if conf.Endpoint == "" {
+		conf.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 512
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = 5 * time.Second
+	}
+	if conf.MaxQueueSize == 0 {
+		conf.MaxQueueSize = 2048
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if conf.OnError == nil {
+		conf.OnError = func(error) {}
+	}
+	e := &OTLPExporter{
+		conf: conf,
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export fulfills the Exporter interface.
+func (e *OTLPExporter) Export(s *Span) {
+	e.mutex.Lock()
+	if len(e.pending) >= e.conf.MaxQueueSize {
+		// Drop the oldest span.
+		copy(e.pending, e.pending[1:])
+		e.pending = e.pending[:len(e.pending)-1]
+		atomic.AddUint64(&e.dropped, 1)
+	}
+	e.pending = append(e.pending, s)
+	full := len(e.pending) >= e.conf.BatchSize
+	e.mutex.Unlock()
+	if full {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of spans which were dropped because the queue
+// was full or they could not be sent.
+func (e *OTLPExporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Flush sends the spans which are waiting. When a batch can not be sent, it
+// and the rest of the spans are dropped.
+func (e *OTLPExporter) Flush() error {
+	e.mutex.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mutex.Unlock()
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > e.conf.BatchSize {
+			n = e.conf.BatchSize
+		}
+		if err := e.send(spans[:n]); err != nil {
+			atomic.AddUint64(&e.dropped, uint64(len(spans)))
+			return err
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+// Close stops the background goroutine and sends the spans which are
+// waiting.
+func (e *OTLPExporter) Close() error {
+	close(e.stop)
+	<-e.done
+	return e.Flush()
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.conf.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.full:
+		case <-e.stop:
+			return
+		}
+		if err := e.Flush(); err != nil {
+			e.conf.OnError(err)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.conf.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.conf.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("traceware: collector responded with status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP/JSON structures, see opentelemetry-proto. IDs are hex encoded and
+// 64 bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Flags             uint32         `json:"flags"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	var resource otlpResource
+	if e.conf.ServiceName != "" {
+		resource.Attributes = []otlpKeyValue{otlpAttribute("service.name", e.conf.ServiceName)}
+	}
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/nstogner/httpware/traceware"}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.State,
+			Flags:             uint32(s.Flags),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		}
+		if s.ParentID.IsValid() {
+			span.ParentSpanID = s.ParentID.String()
+		}
+		for k, v := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute(k, v))
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   resource,
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	var v otlpAnyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
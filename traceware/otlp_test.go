@@ -0,0 +1,135 @@
+package traceware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "k" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		requests = append(requests, req)
+		mutex.Unlock()
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(OTLPConfig{
+		Endpoint:      collector.URL + "/v1/traces",
+		Headers:       map[string]string{"X-Api-Key": "k"},
+		ServiceName:   "api",
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		OnError:       func(err error) { t.Error(err) },
+	})
+	h := httpware.Compose(httpware.DefaultErrHandler, New(Config{Exporter: e})).
+		ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return httpware.NewErr("nope", http.StatusBadRequest)
+		})
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	// The full batch is sent in the background, the rest on Close.
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	var spans []otlpSpan
+	for _, req := range requests {
+		rs := req.ResourceSpans[0]
+		attr := rs.Resource.Attributes[0]
+		if attr.Key != "service.name" || *attr.Value.StringValue != "api" {
+			t.Fatalf("unexpected resource: %+v", rs.Resource)
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %v in %v requests", len(spans), len(requests))
+	}
+	s := spans[0]
+	if len(s.TraceID) != 32 || len(s.SpanID) != 16 || s.Kind != KindServer || s.Status.Code != StatusError {
+		t.Fatalf("unexpected span: %+v", s)
+	}
+	if s.StartTimeUnixNano == "" || s.EndTimeUnixNano < s.StartTimeUnixNano {
+		t.Fatalf("unexpected timing: %+v", s)
+	}
+	found := false
+	for _, a := range s.Attributes {
+		if a.Key == "httpware.err.status_code" && a.Value.IntValue != nil && *a.Value.IntValue == "400" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the Err code attribute, got %+v", s.Attributes)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(OTLPConfig{Endpoint: collector.URL, FlushInterval: time.Hour})
+	e.Export(newSpan("x", KindServer, SpanContext{TraceID: newTraceID(), Flags: FlagSampled}, nil))
+	if err := e.Close(); err == nil {
+		t.Fatal("expected an error from the collector")
+	}
+	if e.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped span, got %v", e.Dropped())
+	}
+}
+
+func TestOTLPExporterQueueFull(t *testing.T) {
+	var mutex sync.Mutex
+	var names []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mutex.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					names = append(names, s.Name)
+				}
+			}
+		}
+		mutex.Unlock()
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(OTLPConfig{Endpoint: collector.URL, BatchSize: 10, MaxQueueSize: 2, FlushInterval: time.Hour})
+	for _, name := range []string{"a", "b", "c"} {
+		e.Export(newSpan(name, KindServer, SpanContext{TraceID: newTraceID(), Flags: FlagSampled}, nil))
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped span, got %v", e.Dropped())
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(names) != 2 || names[0] != "b" || names[1] != "c" {
+		t.Fatalf("expected the oldest span to be dropped, got %v", names)
+	}
+}
@@ -0,0 +1,88 @@
+package traceware
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// The W3C Trace Context headers.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// Extract returns the span context propagated in the traceparent and
+// tracestate headers. The boolean is false when traceparent is missing or
+// invalid, in which case a new trace should be started.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, ok := ParseTraceParent(h.Get(TraceParentHeader))
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc.State = strings.Join(h[http.CanonicalHeaderKey(TraceStateHeader)], ",")
+	return sc, true
+}
+
+// Inject sets the traceparent and tracestate headers for the current span,
+// ie: on an outgoing request to another service.
+func Inject(ctx context.Context, h http.Header) {
+	s := SpanFromCtx(ctx)
+	if s == nil {
+		return
+	}
+	h.Set(TraceParentHeader, FormatTraceParent(s.SpanContext))
+	if s.State != "" {
+		h.Set(TraceStateHeader, s.State)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+// FormatTraceParent returns the traceparent header value for sc.
+func FormatTraceParent(sc SpanContext) string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceParent parses a traceparent header value. Versions after 00 are
+// accepted as long as they start with the fields of version 00.
+func ParseTraceParent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, false
+	}
+	version, ok := parseHex(v[0:2], 1)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(v) != 55) || (len(v) > 55 && v[55] != '-') {
+		return sc, false
+	}
+	traceID, ok := parseHex(v[3:35], 16)
+	if !ok {
+		return sc, false
+	}
+	spanID, ok := parseHex(v[36:52], 8)
+	if !ok {
+		return sc, false
+	}
+	flags, ok := parseHex(v[53:55], 1)
+	if !ok {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// parseHex decodes lowercase hex of the given length in bytes.
+func parseHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
@@ -0,0 +1,58 @@
+package traceware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	cases := []struct {
+		Value string
+		Valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		sc, ok := ParseTraceParent(c.Value)
+		if ok != c.Valid {
+			t.Fatalf("%q: expected valid=%v, got %v", c.Value, c.Valid, ok)
+		}
+		if ok && c.Value[:2] == "00" && FormatTraceParent(sc) != c.Value {
+			t.Fatalf("%q: expected to format back to the same value, got %q", c.Value, FormatTraceParent(sc))
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	in := http.Header{}
+	in.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Add(TraceStateHeader, "congo=t61rcWkgMzE")
+	in.Add(TraceStateHeader, "rojo=00f067aa0ba902b7")
+	sc, ok := Extract(in)
+	if !ok || !sc.Sampled() || sc.State != "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7" {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+
+	s := newSpan("child", KindServer, sc, nil)
+	out := http.Header{}
+	Inject(context.WithValue(context.Background(), httpware.SpanKey, s), out)
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + s.SpanID.String() + "-01"
+	if got := out.Get(TraceParentHeader); got != expected {
+		t.Fatalf("expected traceparent %q, got %q", expected, got)
+	}
+	if got := out.Get(TraceStateHeader); got != sc.State {
+		t.Fatalf("expected tracestate %q, got %q", sc.State, got)
+	}
+}
@@ -0,0 +1,195 @@
+package traceware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// FlagSampled is the trace flag which marks a trace as sampled.
+const FlagSampled = 0x01
+
+// SpanContext is the part of a span which is propagated across services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// The vendor specific tracestate header, passed on as is.
+	State string
+}
+
+// Sampled reports whether the span is recorded and exported.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// SpanKind describes the relationship of a span to its parent.
+type SpanKind int
+
+// Span kinds, with the values used by OTLP.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span, with the values used by OTLP.
+type StatusCode int
+
+// Status codes.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Span is a timed operation within a trace. All methods are safe to call on
+// a nil *Span, they do nothing.
+type Span struct {
+	Name string
+	Kind SpanKind
+	SpanContext
+	// Zero for root spans.
+	ParentID SpanID
+	Start    time.Time
+	End      time.Time
+	// Values are strings, bools, ints, int64s or float64s.
+	Attributes    map[string]interface{}
+	Status        StatusCode
+	StatusMessage string
+
+	exporter Exporter
+	mutex    sync.Mutex
+	ended    bool
+}
+
+// SpanFromCtx returns the current span, or nil if there is none.
+func SpanFromCtx(ctx context.Context) *Span {
+	s, _ := ctx.Value(httpware.SpanKey).(*Span)
+	return s
+}
+
+// StartSpan starts a child of the current span. If there is no current span
+// it returns the context unchanged and a nil span, which is safe to use. The
+// span must be ended with Finish.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromCtx(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := newSpan(name, KindInternal, parent.SpanContext, parent.exporter)
+	return context.WithValue(ctx, httpware.SpanKey, s), s
+}
+
+func newSpan(name string, kind SpanKind, parent SpanContext, exporter Exporter) *Span {
+	s := &Span{
+		Name:        name,
+		Kind:        kind,
+		SpanContext: parent,
+		ParentID:    parent.SpanID,
+		Start:       time.Now(),
+		Attributes:  make(map[string]interface{}),
+		exporter:    exporter,
+	}
+	s.SpanID = newSpanID()
+	return s
+}
+
+// SetAttribute records a key-value pair on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Status = code
+	s.StatusMessage = msg
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// Finish records the end time and exports the span if it is sampled. Calls
+// after the first one do nothing.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mutex.Unlock()
+	if s.Sampled() && s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("traceware: reading random bytes: " + err.Error())
+	}
+}
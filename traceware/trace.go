@@ -0,0 +1,133 @@
+/*
+Package traceware provides tracing middleware based on the W3C Trace Context
+recommendation. It continues the trace given in the traceparent and
+tracestate headers, or starts a new one, and records a server span per
+request. Spans are sent to an Exporter, ie: JSONExporter or OTLPExporter. It
+implements the httpware.Middleware interface for easy composition with other
+middleware.
+*/
+package traceware
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nstogner/httpware"
+)
+
+// Config is used to initialize a new instance of this middleware.
+type Config struct {
+	// Receives the sampled spans.
+	Exporter Exporter
+	// The fraction of new traces which are sampled, ie: 0.1 samples one in
+	// ten. Zero samples them all. Requests which continue a trace follow the
+	// sampled flag of the caller.
+	SampleRate float64
+}
+
+// Middle creates a server span for every request.
+type Middle struct {
+	conf Config
+
+	mutex sync.Mutex
+	rnd   *rand.Rand
+}
+
+// New returns a new instance of the middleware.
+func New(conf Config) *Middle {
+	return &Middle{
+		conf: conf,
+		rnd:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Handle takes the next handler as an argument and wraps it in this middleware.
+func (m *Middle) Handle(next httpware.Handler) httpware.Handler {
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		parent, ok := Extract(r.Header)
+		if !ok {
+			parent = SpanContext{TraceID: newTraceID()}
+			if m.sample() {
+				parent.Flags = FlagSampled
+			}
+		}
+
+		name := r.Method
+		route, _ := ctx.Value(httpware.RouteKey).(string)
+		if route != "" {
+			name += " " + route
+		}
+		s := newSpan(name, KindServer, parent, m.conf.Exporter)
+		s.SetAttribute("http.request.method", r.Method)
+		s.SetAttribute("url.path", r.URL.Path)
+		if route != "" {
+			s.SetAttribute("http.route", route)
+		}
+		defer s.Finish()
+
+		sw := httpware.WrapWriter(w)
+		err := next.ServeHTTPCtx(context.WithValue(ctx, httpware.SpanKey, s), sw.Writer(), r)
+
+		status := sw.Status()
+		if err != nil {
+			code := http.StatusInternalServerError
+			if httpErr, ok := err.(httpware.Err); ok {
+				code = httpErr.StatusCode
+			}
+			s.SetAttribute("httpware.err.status_code", code)
+			if status == 0 {
+				status = code
+			}
+			s.SetError(err)
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.SetAttribute("http.response.status_code", status)
+		if status >= 500 && err == nil {
+			s.SetStatus(StatusError, http.StatusText(status))
+		}
+		return err
+	})
+}
+
+func (m *Middle) sample() bool {
+	if m.conf.SampleRate <= 0 || m.conf.SampleRate >= 1 {
+		return true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rnd.Float64() < m.conf.SampleRate
+}
+
+// Wrap returns middleware which records a child span with the given name
+// around mdlw, ie:
+//
+//	httpware.Compose(errWare,
+//		traceware.New(conf),
+//		traceware.Wrap("logware", logware.New(logware.Defaults)),
+//	)
+//
+// The span covers mdlw and everything downstream of it.
+func Wrap(name string, mdlw httpware.Middleware) httpware.Middleware {
+	return wrapped{name: name, mdlw: mdlw}
+}
+
+type wrapped struct {
+	name string
+	mdlw httpware.Middleware
+}
+
+func (wr wrapped) Handle(next httpware.Handler) httpware.Handler {
+	h := wr.mdlw.Handle(next)
+	return httpware.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		ctx, s := StartSpan(ctx, wr.name)
+		defer s.Finish()
+		err := h.ServeHTTPCtx(ctx, w, r)
+		s.SetError(err)
+		return err
+	})
+}
@@ -0,0 +1,144 @@
+package traceware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nstogner/httpware"
+)
+
+// collector is an Exporter which keeps the spans in memory.
+type collector struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func (c *collector) Export(s *Span) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.spans = append(c.spans, s)
+}
+
+func (c *collector) reset() []*Span {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	spans := c.spans
+	c.spans = nil
+	return spans
+}
+
+func TestServerSpan(t *testing.T) {
+	c := &collector{}
+	m := httpware.Compose(httpware.DefaultErrHandler, New(Config{Exporter: c}))
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/missing":
+			return httpware.NewErr("not found", http.StatusNotFound)
+		case "/broken":
+			return errors.New("boom")
+		}
+		return nil
+	})
+	serve := func(path, traceparent string) *Span {
+		r := httptest.NewRequest("GET", path, nil)
+		if traceparent != "" {
+			r.Header.Set(TraceParentHeader, traceparent)
+		}
+		ctx := context.WithValue(context.Background(), httpware.RouteKey, "/items/:id")
+		h.ServeHTTPCtx(ctx, httptest.NewRecorder(), r)
+		spans := c.reset()
+		if len(spans) != 1 {
+			t.Fatalf("%s: expected 1 span, got %v", path, len(spans))
+		}
+		return spans[0]
+	}
+
+	s := serve("/", "")
+	if s.Name != "GET /items/:id" || s.Kind != KindServer || s.ParentID.IsValid() {
+		t.Fatalf("unexpected root span: %+v", s)
+	}
+	if s.Attributes["http.route"] != "/items/:id" || s.Attributes["http.response.status_code"] != http.StatusOK {
+		t.Fatalf("unexpected attributes: %v", s.Attributes)
+	}
+	if s.Status != StatusUnset || s.End.Before(s.Start) {
+		t.Fatalf("unexpected status or timing: %+v", s)
+	}
+
+	s = serve("/missing", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if s.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected the incoming trace to be continued, got %+v", s.SpanContext)
+	}
+	if s.Status != StatusError || s.StatusMessage != "not found" {
+		t.Fatalf("expected an error status, got %v %q", s.Status, s.StatusMessage)
+	}
+	if s.Attributes["httpware.err.status_code"] != http.StatusNotFound || s.Attributes["http.response.status_code"] != http.StatusNotFound {
+		t.Fatalf("expected the Err code to be recorded, got %v", s.Attributes)
+	}
+
+	s = serve("/broken", "")
+	if s.Attributes["http.response.status_code"] != http.StatusInternalServerError || s.Status != StatusError {
+		t.Fatalf("expected a server error, got %v", s.Attributes)
+	}
+
+	// Unsampled callers are not exported.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if n := len(c.reset()); n != 0 {
+		t.Fatalf("expected no spans for an unsampled trace, got %v", n)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	c := &collector{}
+	var inner *Span
+	m := httpware.Compose(httpware.DefaultErrHandler,
+		New(Config{Exporter: c}),
+		Wrap("inner", noopMiddleware{}),
+	)
+	h := m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		inner = SpanFromCtx(ctx)
+		return nil
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := c.reset()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %v", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if child != inner || child.Name != "inner" || child.Kind != KindInternal {
+		t.Fatalf("unexpected child span: %+v", child)
+	}
+	if child.TraceID != server.TraceID || child.ParentID != server.SpanID {
+		t.Fatal("expected the child span to belong to the server span")
+	}
+}
+
+type noopMiddleware struct{}
+
+func (noopMiddleware) Handle(next httpware.Handler) httpware.Handler {
+	return next
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	m := httpware.Compose(httpware.DefaultErrHandler, New(Config{Exporter: NewJSONExporter(&buf)}))
+	m.ThenFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["name"] != "POST" || got["kind"] != "server" || len(got["traceId"].(string)) != 32 {
+		t.Fatalf("unexpected span: %v", got)
+	}
+}
@@ -0,0 +1,205 @@
+package httpware
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// StatusWriter wraps a http.ResponseWriter and records the status code and
+// the number of bytes written to it, so that middleware can inspect the
+// response after calling the next handler. It has the methods of the optional
+// http.Flusher, http.CloseNotifier, http.Hijacker and io.ReaderFrom
+// interfaces whether the underlying http.ResponseWriter supports them or not,
+// so pass Writer to the next handler rather than the StatusWriter itself.
+type StatusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// WrapWriter returns a StatusWriter for w. If w already is a StatusWriter (or
+// its Writer) it is returned as is, so that several middleware can share it.
+func WrapWriter(w http.ResponseWriter) *StatusWriter {
+	if e, ok := w.(interface{ interceptor() Interceptor }); ok {
+		if sw, ok := e.interceptor().(*StatusWriter); ok {
+			return sw
+		}
+	}
+	if sw, ok := w.(*StatusWriter); ok {
+		return sw
+	}
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// Writer returns the http.ResponseWriter to pass to the next handler. It
+// writes to w, but only implements the optional interfaces which the
+// underlying http.ResponseWriter implements.
+func (w *StatusWriter) Writer() http.ResponseWriter {
+	return Expose(w, w.ResponseWriter)
+}
+
+// Status returns the status code which was written, or zero if nothing has
+// been written yet.
+func (w *StatusWriter) Status() int {
+	return w.status
+}
+
+// Written returns the number of body bytes which were written.
+func (w *StatusWriter) Written() int64 {
+	return w.written
+}
+
+// WriteHeader fulfills the http.ResponseWriter interface.
+func (w *StatusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write fulfills the http.ResponseWriter interface.
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom fulfills the io.ReaderFrom interface. It uses the ReadFrom method
+// of the underlying http.ResponseWriter when there is one, which allows
+// http.ServeContent to use sendfile.
+func (w *StatusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.written += n
+	return n, err
+}
+
+// Flush fulfills the http.Flusher interface.
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// CloseNotify fulfills the http.CloseNotifier interface.
+func (w *StatusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// Hijack fulfills the http.Hijacker interface.
+func (w *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("httpware: the http.ResponseWriter does not support hijacking")
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Interceptor is a http.ResponseWriter which has the methods of all of the
+// optional interfaces, like StatusWriter.
+type Interceptor interface {
+	http.ResponseWriter
+	http.Flusher
+	http.CloseNotifier
+	http.Hijacker
+	io.ReaderFrom
+}
+
+// Expose returns a http.ResponseWriter which passes every call on to w, but
+// only implements those of the http.Flusher, http.CloseNotifier and
+// http.Hijacker interfaces which under implements. Handlers test for these
+// interfaces to decide whether to stream or to take over the connection, so
+// middleware which intercepts the writes to under must not claim more than
+// under supports. io.ReaderFrom is always implemented.
+func Expose(w Interceptor, under http.ResponseWriter) http.ResponseWriter {
+	_, f := under.(http.Flusher)
+	_, c := under.(http.CloseNotifier)
+	_, h := under.(http.Hijacker)
+	e := exposed{w, under}
+	switch {
+	case f && c && h:
+		return struct {
+			exposed
+			http.Flusher
+			http.CloseNotifier
+			http.Hijacker
+		}{e, w, w, w}
+	case f && c:
+		return struct {
+			exposed
+			http.Flusher
+			http.CloseNotifier
+		}{e, w, w}
+	case f && h:
+		return struct {
+			exposed
+			http.Flusher
+			http.Hijacker
+		}{e, w, w}
+	case c && h:
+		return struct {
+			exposed
+			http.CloseNotifier
+			http.Hijacker
+		}{e, w, w}
+	case f:
+		return struct {
+			exposed
+			http.Flusher
+		}{e, w}
+	case c:
+		return struct {
+			exposed
+			http.CloseNotifier
+		}{e, w}
+	case h:
+		return struct {
+			exposed
+			http.Hijacker
+		}{e, w}
+	}
+	return e
+}
+
+// exposed has the methods which Expose always implements.
+type exposed struct {
+	w     Interceptor
+	under http.ResponseWriter
+}
+
+func (e exposed) Header() http.Header                 { return e.w.Header() }
+func (e exposed) Write(b []byte) (int, error)         { return e.w.Write(b) }
+func (e exposed) WriteHeader(code int)                { e.w.WriteHeader(code) }
+func (e exposed) ReadFrom(r io.Reader) (int64, error) { return e.w.ReadFrom(r) }
+func (e exposed) interceptor() Interceptor            { return e.w }
+
+// Unwrap returns the underlying http.ResponseWriter, for use by
+// http.ResponseController.
+func (e exposed) Unwrap() http.ResponseWriter {
+	return e.under
+}
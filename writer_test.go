@@ -0,0 +1,68 @@
+package httpware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := WrapWriter(rec)
+	if WrapWriter(w) != w {
+		t.Fatal("expected wrapping a StatusWriter to return it as is")
+	}
+	if w.Status() != 0 {
+		t.Fatalf("expected no status before writing, got %v", w.Status())
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("abc"))
+	io.Copy(w, strings.NewReader("defg"))
+	w.Flush()
+
+	if w.Status() != http.StatusCreated || rec.Code != http.StatusCreated {
+		t.Fatalf("expected status code %v, got %v (recorded %v)", http.StatusCreated, w.Status(), rec.Code)
+	}
+	if w.Written() != 7 || rec.Body.String() != "abcdefg" {
+		t.Fatalf("expected 7 bytes to be written, got %v (%s)", w.Written(), rec.Body.String())
+	}
+	if !rec.Flushed {
+		t.Fatal("expected the flush to be passed on")
+	}
+}
+
+func TestStatusWriterInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := WrapWriter(rec)
+	w := sw.Writer()
+	if WrapWriter(w) != sw {
+		t.Fatal("expected wrapping the Writer of a StatusWriter to return the StatusWriter")
+	}
+	// A ResponseRecorder is a Flusher, but neither a CloseNotifier nor a
+	// Hijacker.
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected the Writer to be a http.Flusher")
+	}
+	if _, ok := w.(http.CloseNotifier); ok {
+		t.Fatal("expected the Writer not to be a http.CloseNotifier")
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		t.Fatal("expected the Writer not to be a http.Hijacker")
+	}
+	f.Flush()
+	if sw.Status() != http.StatusOK || !rec.Flushed {
+		t.Fatalf("expected the flush to be recorded and passed on, got %v", sw.Status())
+	}
+
+	// Without any of the interfaces.
+	w = WrapWriter(struct{ http.ResponseWriter }{rec}).Writer()
+	if _, ok := w.(http.Flusher); ok {
+		t.Fatal("expected the Writer not to be a http.Flusher")
+	}
+	if _, ok := w.(io.ReaderFrom); !ok {
+		t.Fatal("expected the Writer to be an io.ReaderFrom")
+	}
+}